@@ -90,9 +90,12 @@ func testTarget(t *testing.T, target *prog.Target, full bool) {
 		opts = allOptionsSingle(target.OS)
 		opts = append(opts, ExecutorOpts)
 	} else {
-		minimized, _ := prog.Minimize(syzProg, -1, false, func(p *prog.Prog, call int, _ int) bool {
+		minimized, _, err := prog.Minimize(syzProg, -1, false, func(p *prog.Prog, call int, _ int) bool {
 			return len(p.Calls) == len(syzProg.Calls)
 		})
+		if err != nil {
+			t.Fatal(err)
+		}
 		p.Calls = append(p.Calls, minimized.Calls...)
 		opts = allOptionsPermutations(target.OS)
 	}
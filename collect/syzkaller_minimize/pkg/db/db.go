@@ -63,6 +63,18 @@ func Open(filename string, repair bool) (*DB, error) {
 	return db, deserializeErr
 }
 
+// Deserialize parses database contents from data.
+// The returned database is not backed by a file and must not be modified.
+func Deserialize(data []byte) (*DB, error) {
+	db := new(DB)
+	var err error
+	db.Version, db.Records, db.uncompacted, err = deserializeDB(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
 func (db *DB) Save(key string, val []byte, seq uint64) {
 	if seq == seqDeleted {
 		panic("reserved seq")
@@ -118,6 +118,34 @@ func TestLarge(t *testing.T) {
 	}
 }
 
+func TestDeserialize(t *testing.T) {
+	fn := tempFile(t)
+	defer os.Remove(fn)
+	db, err := Open(fn, false)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	db.Save("1", []byte("ab"), 1)
+	db.Save("23", []byte("abcd"), 2)
+	if err := db.Flush(); err != nil {
+		t.Fatalf("failed to flush db: %v", err)
+	}
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db1, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("failed to deserialize db: %v", err)
+	}
+	if !reflect.DeepEqual(db1.Records, db.Records) {
+		t.Fatalf("bad deserialized db: %v, want: %v", db1.Records, db.Records)
+	}
+	if _, err := Deserialize([]byte("some log")); err == nil {
+		t.Fatalf("deserialized invalid db")
+	}
+}
+
 func TestOpenInvalid(t *testing.T) {
 	f, err := os.CreateTemp("", "syz-db-test")
 	if err != nil {
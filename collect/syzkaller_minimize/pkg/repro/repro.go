@@ -418,7 +418,7 @@ func (ctx *context) minimizeProg(res *Result) (*Result, error) {
 		ctx.stats.MinimizeProgTime = time.Since(start)
 	}()
 
-	res.Prog, _ = prog.Minimize(res.Prog, -1, true,
+	p, _, err := prog.Minimize(res.Prog, -1, true,
 		func(p1 *prog.Prog, callIndex int, _ int) bool {
 			crashed, err := ctx.testProg(p1, res.Duration, res.Opts)
 			if err != nil {
@@ -427,6 +427,10 @@ func (ctx *context) minimizeProg(res *Result) (*Result, error) {
 			}
 			return crashed
 		})
+	if err != nil {
+		return nil, err
+	}
+	res.Prog = p
 
 	return res, nil
 }
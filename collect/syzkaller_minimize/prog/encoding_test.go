@@ -391,10 +391,13 @@ func TestSerializeDeserializeRandom(t *testing.T) {
 			if ok, _, _ := testSerializeDeserialize(t, p0, data0, data1); ok {
 				continue
 			}
-			p0, _ = Minimize(p0, -1, false, func(p1 *Prog, _ int, _ int) bool {
+			p0, _, err := Minimize(p0, -1, false, func(p1 *Prog, _ int, _ int) bool {
 				ok, _, _ := testSerializeDeserialize(t, p1, data0, data1)
 				return !ok
 			})
+			if err != nil {
+				t.Fatal(err)
+			}
 			ok, n0, n1 := testSerializeDeserialize(t, p0, data0, data1)
 			if ok {
 				t.Log("flaky?")
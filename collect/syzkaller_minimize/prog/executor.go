@@ -0,0 +1,29 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+// Executor executes programs on behalf of the minimizer. It decouples the minimization logic
+// from the real executor, so that tests can supply a fake returning scripted coverage hashes.
+type Executor interface {
+	// Exec executes p and returns the hash of the coverage of call callIndex
+	// (see SignalHash). ok is false if the call was not executed or failed.
+	Exec(p *Prog, callIndex int) (covHash uint32, ok bool)
+}
+
+// ExecutorPredicate returns a Minimize predicate that accepts a candidate if the preserved call
+// still has the covHash coverage hash. A candidate is executed up to retries times (at least once)
+// until the hash matches, which tolerates flaky executions.
+func ExecutorPredicate(exec Executor, covHash uint32, retries int) func(*Prog, int, int) bool {
+	if retries < 1 {
+		retries = 1
+	}
+	return func(p *Prog, callIndex, _ int) bool {
+		for i := 0; i < retries; i++ {
+			if hash, ok := exec.Exec(p, callIndex); ok && hash == covHash {
+				return true
+			}
+		}
+		return false
+	}
+}
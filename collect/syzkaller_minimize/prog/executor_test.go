@@ -0,0 +1,153 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"testing"
+)
+
+// scriptedExecutor is a deterministic fake Executor, hash computes the coverage hash
+// of the call from the program. The first fail executions fail.
+type scriptedExecutor struct {
+	hash  func(p *Prog, callIndex int) uint32
+	fail  int
+	execs int
+}
+
+func (exec *scriptedExecutor) Exec(p *Prog, callIndex int) (uint32, bool) {
+	exec.execs++
+	if exec.execs <= exec.fail {
+		return 0, false
+	}
+	return exec.hash(p, callIndex), true
+}
+
+// hasCall returns true if p has a call named name in calls [from, to).
+func hasCall(p *Prog, name string, from, to int) bool {
+	for i := from; i < to && i < len(p.Calls); i++ {
+		if p.Calls[i].Meta.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func callNames(p *Prog) []string {
+	var names []string
+	for _, c := range p.Calls {
+		names = append(names, c.Meta.Name)
+	}
+	return names
+}
+
+func TestExecutorPredicate(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("getpid()\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		fail    int
+		retries int
+		hash    uint32
+		ok      bool
+		execs   int
+	}{
+		{0, 3, 1, true, 1},
+		{2, 3, 1, true, 3},
+		{3, 3, 1, false, 3},
+		{0, 3, 2, false, 3},
+		{1, 0, 1, false, 1},
+	}
+	for i, test := range tests {
+		exec := &scriptedExecutor{
+			hash: func(*Prog, int) uint32 { return 1 },
+			fail: test.fail,
+		}
+		ok := ExecutorPredicate(exec, test.hash, test.retries)(p, 0, 1)
+		if ok != test.ok || exec.execs != test.execs {
+			t.Errorf("#%v: got %v after %v execs, want %v after %v", i, ok, exec.execs, test.ok, test.execs)
+		}
+	}
+}
+
+func TestMinimizeScriptedExecutor(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"getpid()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"+
+		"getuid()\n"+
+		"sched_yield()\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Coverage of pipe2 depends only on a preceding getpid.
+	exec := &scriptedExecutor{
+		hash: func(p *Prog, callIndex int) uint32 {
+			if hasCall(p, "getpid", 0, callIndex) {
+				return 1
+			}
+			return 2
+		},
+	}
+	p1, ci, err := Minimize(p, 2, false, ExecutorPredicate(exec, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := callNames(p1); len(got) != 2 || got[0] != "getpid" || got[1] != "pipe2" || ci != 1 {
+		t.Fatalf("bad minimization result: call index %v\n%s", ci, p1.Serialize())
+	}
+}
+
+func TestRemovePostCallsScripted(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"+
+		"getpid()\n"+
+		"getuid()\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		// matters is the name of the post call that affects coverage of pipe2.
+		matters string
+		calls   int
+		execs   int
+	}{
+		{"", 2, 1},
+		// The bulk removal is rejected, but the other post call can be removed alone.
+		{"getuid", 3, 3},
+		{"getpid", 3, 3},
+	}
+	for i, test := range tests {
+		exec := &scriptedExecutor{
+			hash: func(p *Prog, callIndex int) uint32 {
+				if test.matters != "" && !hasCall(p, test.matters, callIndex+1, len(p.Calls)) {
+					return 2
+				}
+				return 1
+			},
+		}
+		p1, ci, err := removePostCalls(p, 1, ExecutorPredicate(exec, 1, 1), &MinimizeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p1.Calls) != test.calls || ci != 1 || exec.execs != test.execs {
+			t.Errorf("#%v: got %v calls, call index %v after %v execs, want %v calls after %v execs",
+				i, len(p1.Calls), ci, exec.execs, test.calls, test.execs)
+		}
+		if test.matters != "" && !hasCall(p1, test.matters, ci+1, len(p1.Calls)) {
+			t.Errorf("#%v: %v is removed\n%s", i, test.matters, p1.Serialize())
+		}
+	}
+}
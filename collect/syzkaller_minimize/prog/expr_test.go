@@ -169,7 +169,8 @@ func TestConditionalMinimize(t *testing.T) {
 			assert.NoError(tt, err)
 			p, err := target.Deserialize([]byte(test.input), Strict)
 			assert.NoError(tt, err)
-			p1, _ := Minimize(p, 0, false, test.pred)
+			p1, _, err := Minimize(p, 0, false, test.pred)
+			assert.NoError(tt, err)
 			res := p1.Serialize()
 			assert.Equal(tt, test.output, strings.TrimSpace(string(res)))
 		})
@@ -0,0 +1,387 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DiffInfluenceMatrix compares two influence matrices edge by edge and returns the number
+// of edges present only in a, only in b and in both. The matrices may have different
+// dimensions (e.g. snapshots taken for different descriptions), cells missing in one
+// of the matrices are treated as absent edges.
+func DiffInfluenceMatrix(a, b [][]uint8) (onlyA, onlyB, both int) {
+	forEachInfluenceCell(a, b, func(src, dest int, inA, inB bool) {
+		switch {
+		case inA && inB:
+			both++
+		case inA:
+			onlyA++
+		case inB:
+			onlyB++
+		}
+	})
+	return
+}
+
+// PrintInfluenceMatrixDiff prints edges removed (present only in a) and added (present only in b)
+// when going from matrix a to matrix b as "-/+ src -> dest" syscall name pairs.
+// A typical use is to snapshot target.InfluenceMatrix before and after a learning run.
+func (target *Target) PrintInfluenceMatrixDiff(w io.Writer, a, b [][]uint8) {
+	forEachInfluenceCell(a, b, func(src, dest int, inA, inB bool) {
+		if inA == inB {
+			return
+		}
+		sign := "+"
+		if inA {
+			sign = "-"
+		}
+		fmt.Fprintf(w, "%v %v -> %v\n", sign, target.syscallName(src), target.syscallName(dest))
+	})
+}
+
+// SyscallIndex maps syscall IDs (influence matrix indices) to syscall names.
+// Revision identifies the descriptions, IDs are not stable across revisions.
+type SyscallIndex struct {
+	OS       string         `json:"os"`
+	Arch     string         `json:"arch"`
+	Revision string         `json:"revision"`
+	Syscalls map[int]string `json:"syscalls"`
+}
+
+// DumpSyscallIndex writes the SyscallIndex of the target as JSON.
+func (target *Target) DumpSyscallIndex(w io.Writer) error {
+	index := &SyscallIndex{
+		OS:       target.OS,
+		Arch:     target.Arch,
+		Revision: target.Revision,
+		Syscalls: make(map[int]string, len(target.Syscalls)),
+	}
+	for _, c := range target.Syscalls {
+		index.Syscalls[c.ID] = c.Name
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(index)
+}
+
+func (target *Target) syscallName(id int) string {
+	if id < 0 || id >= len(target.Syscalls) {
+		return fmt.Sprintf("#%v", id)
+	}
+	return target.Syscalls[id].Name
+}
+
+func forEachInfluenceCell(a, b [][]uint8, fn func(src, dest int, inA, inB bool)) {
+	rows := len(a)
+	if len(b) > rows {
+		rows = len(b)
+	}
+	for src := 0; src < rows; src++ {
+		rowA, rowB := influenceRow(a, src), influenceRow(b, src)
+		cols := len(rowA)
+		if len(rowB) > cols {
+			cols = len(rowB)
+		}
+		for dest := 0; dest < cols; dest++ {
+			inA := dest < len(rowA) && rowA[dest] != 0
+			inB := dest < len(rowB) && rowB[dest] != 0
+			if inA || inB {
+				fn(src, dest, inA, inB)
+			}
+		}
+	}
+}
+
+func influenceRow(m [][]uint8, src int) []uint8 {
+	if src >= len(m) {
+		return nil
+	}
+	return m[src]
+}
+
+// InfluenceEdge is a key of Target.SparseInfluence, it means that syscall Src influences syscall Dest
+// (both are syscall IDs).
+type InfluenceEdge struct {
+	Src  int
+	Dest int
+}
+
+// hasInfluence returns true if either the influence matrix or its sparse variant is analyzed.
+func (target *Target) hasInfluence() bool {
+	return target.InfluenceMatrix != nil || target.SparseInfluence != nil
+}
+
+// influences returns true if syscall src influences syscall dest.
+func (target *Target) influences(src, dest int) bool {
+	target.influenceMu.RLock()
+	defer target.influenceMu.RUnlock()
+	return target.influencesLocked(src, dest)
+}
+
+func (target *Target) influencesLocked(src, dest int) bool {
+	if target.SparseInfluence != nil {
+		return target.SparseInfluence[InfluenceEdge{src, dest}]
+	}
+	return matrixCovers(target.InfluenceMatrix, src, dest) && target.InfluenceMatrix[src][dest] == 1
+}
+
+func matrixCovers(m [][]uint8, src, dest int) bool {
+	return src >= 0 && src < len(m) && dest >= 0 && dest < len(m[src])
+}
+
+// CheckInfluence returns an error if the influence was computed for another revision of descriptions
+// (e.g. restored with SetInfluenceMatrix from a snapshot) or the influence matrix has no cell for some
+// pair of calls of p. Syscall IDs of p don't match the matrix indices in such case, even if they are
+// within the matrix bounds, so the matrix would connect wrong syscalls.
+// Influence can't be used to minimize such p, Minimize falls back to the vanilla call removal.
+func (target *Target) CheckInfluence(p *Prog) error {
+	target.influenceMu.RLock()
+	defer target.influenceMu.RUnlock()
+	if err := target.checkInfluenceRevision(); err != nil {
+		return err
+	}
+	if target.InfluenceMatrix == nil {
+		return nil
+	}
+	for _, src := range p.Calls {
+		for _, dest := range p.Calls {
+			if !matrixCovers(target.InfluenceMatrix, src.Meta.ID, dest.Meta.ID) {
+				return fmt.Errorf("influence matrix has no cell for %v (ID %v) -> %v (ID %v), "+
+					"it was probably computed for another revision than %v",
+					src.Meta.Name, src.Meta.ID, dest.Meta.Name, dest.Meta.ID, target.Revision)
+			}
+		}
+	}
+	return nil
+}
+
+func (target *Target) checkInfluenceRevision() error {
+	if target.hasInfluence() && target.influenceRevision != "" && target.influenceRevision != target.Revision {
+		return fmt.Errorf("influence was computed for revision %v, but descriptions are at revision %v",
+			target.influenceRevision, target.Revision)
+	}
+	return nil
+}
+
+// Influences returns true if syscall srcName influences syscall destName.
+// It fails if either syscall is unknown or influence is not analyzed.
+func (target *Target) Influences(srcName, destName string) (bool, error) {
+	src := target.SyscallMap[srcName]
+	if src == nil {
+		return false, fmt.Errorf("unknown syscall %q", srcName)
+	}
+	dest := target.SyscallMap[destName]
+	if dest == nil {
+		return false, fmt.Errorf("unknown syscall %q", destName)
+	}
+	if !target.hasInfluence() {
+		return false, fmt.Errorf("influence is not analyzed")
+	}
+	return target.influences(src.ID, dest.ID), nil
+}
+
+// addInfluence adds the src->dest edge and returns true if it was not present before.
+// It is safe to call concurrently with other addInfluence and influences calls.
+func (target *Target) addInfluence(src, dest int) bool {
+	target.influenceMu.Lock()
+	defer target.influenceMu.Unlock()
+	if target.influencesLocked(src, dest) {
+		return false
+	}
+	if target.checkInfluenceRevision() != nil ||
+		target.SparseInfluence == nil && !matrixCovers(target.InfluenceMatrix, src, dest) {
+		// Stale matrix, see CheckInfluence.
+		return false
+	}
+	if target.SparseInfluence != nil {
+		target.SparseInfluence[InfluenceEdge{src, dest}] = true
+	} else {
+		target.InfluenceMatrix[src][dest] = 1
+	}
+	target.influenceDirty.Add(1)
+	return true
+}
+
+// ValidateInfluenceMatrix checks that the analyzed influence is consistent with the syscalls of target:
+// the matrix must be square with a row and a column per syscall and hold only 0 and 1 cells,
+// edges of the sparse influence must refer to existing syscalls.
+func (target *Target) ValidateInfluenceMatrix() error {
+	target.influenceMu.RLock()
+	defer target.influenceMu.RUnlock()
+	n := len(target.Syscalls)
+	if target.InfluenceMatrix != nil && target.SparseInfluence != nil {
+		return fmt.Errorf("both the influence matrix and the sparse influence are set")
+	}
+	if target.InfluenceMatrix != nil {
+		if len(target.InfluenceMatrix) != n {
+			return fmt.Errorf("influence matrix has %v rows, want %v", len(target.InfluenceMatrix), n)
+		}
+		for src, row := range target.InfluenceMatrix {
+			if len(row) != n {
+				return fmt.Errorf("influence matrix row %v has %v columns, want %v", src, len(row), n)
+			}
+			for dest, cell := range row {
+				if cell > 1 {
+					return fmt.Errorf("influence matrix cell %v->%v has value %v", src, dest, cell)
+				}
+			}
+		}
+	}
+	for edge := range target.SparseInfluence {
+		if edge.Src < 0 || edge.Src >= n || edge.Dest < 0 || edge.Dest >= n {
+			return fmt.Errorf("sparse influence edge %v->%v is out of range [0, %v)", edge.Src, edge.Dest, n)
+		}
+	}
+	return nil
+}
+
+// InfluenceDegrees returns the number of incoming and outgoing influence edges per syscall ID.
+// Syscalls with a high out-degree are hubs that keep most other calls alive during call removal
+// (candidates for MinimizeOptions.IgnoreInfluencers). Both are nil if influence is not analyzed.
+func (target *Target) InfluenceDegrees() (inDeg, outDeg []int) {
+	target.influenceMu.RLock()
+	defer target.influenceMu.RUnlock()
+	if !target.hasInfluence() {
+		return nil, nil
+	}
+	inDeg = make([]int, len(target.Syscalls))
+	outDeg = make([]int, len(target.Syscalls))
+	for src, row := range target.InfluenceMatrix {
+		for dest, cell := range row {
+			if cell == 1 {
+				outDeg[src]++
+				inDeg[dest]++
+			}
+		}
+	}
+	for edge, ok := range target.SparseInfluence {
+		if ok {
+			outDeg[edge.Src]++
+			inDeg[edge.Dest]++
+		}
+	}
+	return inDeg, outDeg
+}
+
+// CloneInfluenceMatrix returns a deep copy of the influence matrix, e.g. to restore it
+// with SetInfluenceMatrix after a run that modifies it. Returns nil if the matrix is not analyzed.
+func (target *Target) CloneInfluenceMatrix() [][]uint8 {
+	target.influenceMu.RLock()
+	defer target.influenceMu.RUnlock()
+	return cloneInfluenceMatrix(target.InfluenceMatrix)
+}
+
+// SetInfluenceMatrix replaces the influence of target with a deep copy of m, so that m itself
+// is never modified by learning and can be used to restore the influence again.
+// revision is the Revision of descriptions m was computed for, syscall IDs of other revisions
+// don't match the matrix indices (see CheckInfluence).
+// It drops the sparse influence and resets InfluenceMatrixDirtyCount.
+func (target *Target) SetInfluenceMatrix(m [][]uint8, revision string) {
+	target.influenceMu.Lock()
+	defer target.influenceMu.Unlock()
+	target.InfluenceMatrix = cloneInfluenceMatrix(m)
+	target.SparseInfluence = nil
+	target.influenceRevision = revision
+	target.influenceDirty.Store(0)
+}
+
+func cloneInfluenceMatrix(m [][]uint8) [][]uint8 {
+	if m == nil {
+		return nil
+	}
+	clone := make([][]uint8, len(m))
+	for i, row := range m {
+		clone[i] = append([]uint8(nil), row...)
+	}
+	return clone
+}
+
+// InfluenceMatrixDirtyCount returns the number of edges added to the influence by dynamic learning
+// since the last AnalyzeStaticInfluence, AnalyzeSparseInfluence or SetInfluenceMatrix call.
+// Zero means that learning didn't change the influence.
+func (target *Target) InfluenceMatrixDirtyCount() int {
+	return int(target.influenceDirty.Load())
+}
+
+// learnInfluence is invoked when removal of call idx from p0 (which resulted in p) was rejected.
+// If coverage of the call following the removed one changed as well, the removed call influences it,
+// so the missing edge is added to the target influence. Both programs must have per-call coverage
+// hashes recorded. Returns true if the influence was updated.
+func learnInfluence(target *Target, p0, p *Prog, idx int) bool {
+	if !target.hasInfluence() || !p.Minimize_ExecuteStatus || idx+1 >= len(p0.Calls) {
+		return false
+	}
+	// After the removal the next call is shifted to idx.
+	hash0, hash := p0.callCovHash(idx+1), p.callCovHash(idx)
+	if hash0 == 0 || hash == 0 || hash0 == hash {
+		return false
+	}
+	return target.addInfluence(p0.Calls[idx].Meta.ID, p0.Calls[idx+1].Meta.ID)
+}
+
+// InfluenceEdgeReasons returns sorted names of resources that make syscall srcID influence syscall destID
+// according to the last static influence analysis (srcID produces and destID consumes them).
+// A resource consumed as a compatible resource of another type is reported as "produced->consumed"
+// (e.g. sock_tcp->sock). It explains edges caused by ubiquitous resources like fd.
+// Returns nil if static analysis doesn't connect the syscalls (e.g. for edges added by learning)
+// or influence is not analyzed.
+func (target *Target) InfluenceEdgeReasons(srcID, destID int) []string {
+	if !target.hasInfluence() || srcID == destID {
+		return nil
+	}
+	var reasons []string
+	for _, src := range target.influenceUsages {
+		if !containsID(src.dirOut_ids, srcID) {
+			continue
+		}
+		for _, dest := range target.influenceUsages {
+			if !src.connects(dest) || !containsID(dest.dirIn_ids, destID) {
+				continue
+			}
+			reason := src.name
+			if dest != src {
+				reason += "->" + dest.name
+			}
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+func containsID(ids []int, id int) bool {
+	for _, id1 := range ids {
+		if id1 == id {
+			return true
+		}
+	}
+	return false
+}
+
+// InfluenceDescendants returns indices of calls after callIndex in p that transitively depend on it
+// according to the target influence, i.e. the dual of the ancestors that call removal preserves.
+// It explains why removing a setup call made removal of later calls possible as well.
+// The result is empty if influence is not analyzed.
+func InfluenceDescendants(p *Prog, callIndex int) map[int]bool {
+	descendants := make(map[int]bool)
+	if !p.Target.hasInfluence() || p.Target.CheckInfluence(p) != nil {
+		return descendants
+	}
+	queue := NewIntQueue()
+	queue.Enqueue(callIndex)
+	for queue.Length() > 0 {
+		id, _ := queue.Dequeue()
+		for j := id + 1; j < len(p.Calls); j++ {
+			if !descendants[j] && p.Target.influences(p.Calls[id].Meta.ID, p.Calls[j].Meta.ID) {
+				descendants[j] = true
+				queue.Enqueue(j)
+			}
+		}
+	}
+	return descendants
+}
@@ -0,0 +1,713 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package prog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDiffInfluenceMatrix(t *testing.T) {
+	tests := []struct {
+		a, b               [][]uint8
+		onlyA, onlyB, both int
+	}{
+		{nil, nil, 0, 0, 0},
+		{
+			a:    [][]uint8{{0, 1}, {1, 0}},
+			b:    [][]uint8{{0, 1}, {1, 0}},
+			both: 2,
+		},
+		{
+			a:     [][]uint8{{0, 1}, {0, 0}},
+			b:     [][]uint8{{0, 0}, {1, 1}},
+			onlyA: 1,
+			onlyB: 2,
+		},
+		{
+			// Mismatched dimensions: missing cells are absent edges.
+			a:     [][]uint8{{1}},
+			b:     [][]uint8{{1, 1, 0}, {0}, {0, 0, 1}},
+			onlyB: 2,
+			both:  1,
+		},
+		{
+			a:     [][]uint8{{0, 1, 1}, {1}},
+			b:     nil,
+			onlyA: 3,
+		},
+	}
+	for i, test := range tests {
+		onlyA, onlyB, both := DiffInfluenceMatrix(test.a, test.b)
+		if onlyA != test.onlyA || onlyB != test.onlyB || both != test.both {
+			t.Errorf("#%v: got %v/%v/%v, want %v/%v/%v", i, onlyA, onlyB, both,
+				test.onlyA, test.onlyB, test.both)
+		}
+	}
+}
+
+func TestPrintInfluenceMatrixDiff(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	n := len(target.Syscalls)
+	a := make([][]uint8, n)
+	for i := range a {
+		a[i] = make([]uint8, n)
+	}
+	a[0][1] = 1
+	a[1][0] = 1
+	b := [][]uint8{{0, 1}, {0, 0}}
+	b = append(b, make([][]uint8, n)...)
+	b[n+1] = []uint8{1}
+	buf := new(bytes.Buffer)
+	target.PrintInfluenceMatrixDiff(buf, a, b)
+	want := fmt.Sprintf("- %v -> %v\n+ #%v -> %v\n", target.Syscalls[1].Name, target.Syscalls[0].Name,
+		n+1, target.Syscalls[0].Name)
+	if got := buf.String(); got != want {
+		t.Fatalf("got diff:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestLearnInfluence(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p0, err := target.Deserialize([]byte("sched_yield()\n"+
+		"getpid()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p0.RecordCallsCovHash([]uint32{1, 2, 3})
+	src, dest := p0.Calls[0].Meta.ID, p0.Calls[1].Meta.ID
+	tests := []struct {
+		hashes []uint32
+		learn  bool
+	}{
+		{nil, false},
+		{[]uint32{2, 3}, false},
+		{[]uint32{0, 3}, false},
+		{[]uint32{5, 3}, true},
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+		target.SparseInfluence = nil
+	}()
+	for _, sparse := range []bool{false, true} {
+		for i, test := range tests {
+			if sparse {
+				target.SparseInfluence = make(map[InfluenceEdge]bool)
+			} else {
+				target.InfluenceMatrix = make([][]uint8, len(target.Syscalls))
+				for i := range target.InfluenceMatrix {
+					target.InfluenceMatrix[i] = make([]uint8, len(target.Syscalls))
+				}
+			}
+			p := p0.Clone()
+			p.RemoveCall(0)
+			if test.hashes != nil {
+				p.RecordCallsCovHash(test.hashes)
+			}
+			learned := learnInfluence(target, p0, p, 0)
+			if edge := target.influences(src, dest); learned != test.learn || edge != test.learn {
+				t.Errorf("#%v/sparse=%v: learned %v, edge %v, want %v", i, sparse, learned, edge, test.learn)
+			}
+			if learned && learnInfluence(target, p0, p, 0) {
+				t.Errorf("#%v/sparse=%v: the same edge is learned twice", i, sparse)
+			}
+			if want := map[bool]int{false: 0, true: 1}[learned]; target.InfluenceMatrixDirtyCount() != want {
+				t.Errorf("#%v/sparse=%v: dirty count %v, want %v", i, sparse, target.InfluenceMatrixDirtyCount(), want)
+			}
+			target.InfluenceMatrix = nil
+			target.SparseInfluence = nil
+			target.influenceDirty.Store(0)
+		}
+	}
+}
+
+func TestAnalyzeSparseInfluence(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+		target.SparseInfluence = nil
+	}()
+	matrix := target.staticInfluence()
+	calls := make(map[int]bool)
+	for _, name := range []string{"test$produce_common", "test$consume_common",
+		"test$consume_subtype_of_common", "test$res0", "test$res1"} {
+		calls[target.SyscallMap[name].ID] = true
+	}
+	target.AnalyzeSparseInfluence(calls)
+	if target.InfluenceMatrix != nil {
+		t.Fatalf("AnalyzeSparseInfluence allocated the matrix")
+	}
+	edges := 0
+	for src := range matrix {
+		for dest := range matrix[src] {
+			want := matrix[src][dest] == 1 && calls[src] && calls[dest]
+			if want {
+				edges++
+			}
+			if got := target.influences(src, dest); got != want {
+				t.Errorf("%v->%v: sparse influence %v, want %v",
+					target.Syscalls[src].Name, target.Syscalls[dest].Name, got, want)
+			}
+		}
+	}
+	if edges == 0 || len(target.SparseInfluence) != edges {
+		t.Fatalf("got %v sparse edges, want %v (non-zero)", len(target.SparseInfluence), edges)
+	}
+}
+
+func TestAnalyzeStaticInfluenceOf(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+	}()
+	matrix := target.staticInfluence()
+	calls := make(map[int]bool)
+	for _, c := range target.Syscalls {
+		if strings.HasPrefix(c.Name, "test$produce_") || c.Name == "test$consume_common" {
+			calls[c.ID] = true
+		}
+	}
+	target.AnalyzeStaticInfluenceOf(calls)
+	if err := target.ValidateInfluenceMatrix(); err != nil {
+		t.Fatal(err)
+	}
+	edges := 0
+	for src := range matrix {
+		for dest := range matrix[src] {
+			want := matrix[src][dest] == 1 && calls[src] && calls[dest]
+			if want {
+				edges++
+			}
+			if got := target.influences(src, dest); got != want {
+				t.Errorf("%v->%v: influence %v, want %v",
+					target.Syscalls[src].Name, target.Syscalls[dest].Name, got, want)
+			}
+		}
+	}
+	if edges == 0 {
+		t.Fatalf("no edges between the restricted syscalls")
+	}
+}
+
+func TestInfluences(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.Influences("test$produce_common", "test$consume_common"); err == nil {
+		t.Fatalf("no error without analyzed influence")
+	}
+	target.AnalyzeStaticInfluence()
+	defer func() {
+		target.InfluenceMatrix = nil
+	}()
+	tests := []struct {
+		src, dest string
+		want      bool
+		err       bool
+	}{
+		{"test$produce_common", "test$consume_common", true, false},
+		{"test$consume_common", "test$produce_common", false, false},
+		{"test$produce_common", "test$also_produce_common", false, false},
+		{"test$unknown", "test$consume_common", false, true},
+		{"test$produce_common", "test$unknown", false, true},
+	}
+	for _, test := range tests {
+		got, err := target.Influences(test.src, test.dest)
+		if (err != nil) != test.err {
+			t.Errorf("%v -> %v: got error %v, want error %v", test.src, test.dest, err, test.err)
+		}
+		if got != test.want {
+			t.Errorf("%v -> %v: got %v, want %v", test.src, test.dest, got, test.want)
+		}
+	}
+}
+
+func TestInfluenceDescendants(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("r0 = test$produce_inout_res()\n"+
+		"test$update_inout_res(&(0x7f0000000000)=r0)\n"+
+		"test$res0()\n"+
+		"test$consume_inout_res(r0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := InfluenceDescendants(p, 0); len(got) != 0 {
+		t.Fatalf("got descendants %v without analyzed influence", got)
+	}
+	target.AnalyzeStaticInfluence()
+	defer func() {
+		target.InfluenceMatrix = nil
+	}()
+	tests := []struct {
+		callIndex int
+		want      []int
+	}{
+		{0, []int{1, 3}},
+		{1, []int{3}},
+		{2, nil},
+		{3, nil},
+	}
+	for _, test := range tests {
+		got := InfluenceDescendants(p, test.callIndex)
+		want := make(map[int]bool)
+		for _, idx := range test.want {
+			want[idx] = true
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("call %v: got descendants %v, want %v", test.callIndex, got, want)
+		}
+	}
+}
+
+func TestValidateInfluenceMatrix(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+		target.SparseInfluence = nil
+	}()
+	if err := target.ValidateInfluenceMatrix(); err != nil {
+		t.Fatalf("no influence: %v", err)
+	}
+	n := len(target.Syscalls)
+	tests := []struct {
+		name    string
+		corrupt func(m [][]uint8) [][]uint8
+	}{
+		{"missing row", func(m [][]uint8) [][]uint8 { return m[1:] }},
+		{"short row", func(m [][]uint8) [][]uint8 { m[3] = m[3][:n-1]; return m }},
+		{"bad cell", func(m [][]uint8) [][]uint8 { m[2][5] = 2; return m }},
+	}
+	for _, test := range tests {
+		target.AnalyzeStaticInfluence()
+		if err := target.ValidateInfluenceMatrix(); err != nil {
+			t.Fatalf("static influence: %v", err)
+		}
+		target.InfluenceMatrix = test.corrupt(target.InfluenceMatrix)
+		if err := target.ValidateInfluenceMatrix(); err == nil {
+			t.Errorf("%v: no error", test.name)
+		}
+	}
+	target.AnalyzeSparseInfluence(map[int]bool{0: true, 1: true})
+	if err := target.ValidateInfluenceMatrix(); err != nil {
+		t.Fatalf("sparse influence: %v", err)
+	}
+	target.SparseInfluence[InfluenceEdge{0, n}] = true
+	if err := target.ValidateInfluenceMatrix(); err == nil {
+		t.Errorf("out of range sparse edge: no error")
+	}
+}
+
+func TestConcurrentLearning(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+		target.SparseInfluence = nil
+		target.influenceDirty.Store(0)
+	}()
+	const workers, edges = 8, 100
+	n := len(target.Syscalls)
+	for _, sparse := range []bool{false, true} {
+		if sparse {
+			target.AnalyzeSparseInfluence(nil)
+		} else {
+			target.AnalyzeStaticInfluence()
+			for _, row := range target.InfluenceMatrix {
+				for i := range row {
+					row[i] = 0
+				}
+			}
+		}
+		// All workers learn the same edges concurrently with lookups, so every edge
+		// must be counted exactly once.
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < edges; i++ {
+					k := (i + w) % edges
+					src, dest := k%n, k*7%n
+					target.addInfluence(src, dest)
+					target.influences(dest, src)
+				}
+			}(w)
+		}
+		wg.Wait()
+		want := make(map[InfluenceEdge]bool)
+		for i := 0; i < edges; i++ {
+			want[InfluenceEdge{i % n, i * 7 % n}] = true
+		}
+		if got := target.InfluenceMatrixDirtyCount(); got != len(want) {
+			t.Errorf("sparse=%v: got %v learned edges, want %v", sparse, got, len(want))
+		}
+		if err := target.ValidateInfluenceMatrix(); err != nil {
+			t.Errorf("sparse=%v: %v", sparse, err)
+		}
+	}
+}
+
+func TestCloneInfluenceMatrix(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+		target.SparseInfluence = nil
+		target.influenceDirty.Store(0)
+	}()
+	if target.CloneInfluenceMatrix() != nil {
+		t.Fatalf("clone of a nil matrix is not nil")
+	}
+	target.AnalyzeStaticInfluence()
+	base := target.CloneInfluenceMatrix()
+	if !reflect.DeepEqual(base, target.InfluenceMatrix) {
+		t.Fatalf("the clone differs from the matrix")
+	}
+	// Neither modification of the clone nor learning after SetInfluenceMatrix affect the snapshot.
+	base[0][0] ^= 1
+	if reflect.DeepEqual(base, target.InfluenceMatrix) {
+		t.Fatalf("modification of the clone changed the matrix")
+	}
+	base[0][0] ^= 1
+	target.SetInfluenceMatrix(base, target.Revision)
+	src, dest := 1, 2
+	if target.influences(src, dest) {
+		src, dest = dest, src
+	}
+	if !target.addInfluence(src, dest) || base[src][dest] != 0 {
+		t.Fatalf("learning changed the snapshot")
+	}
+	target.SetInfluenceMatrix(base, target.Revision)
+	if target.influences(src, dest) || target.InfluenceMatrixDirtyCount() != 0 {
+		t.Fatalf("the matrix is not restored")
+	}
+	target.AnalyzeSparseInfluence(nil)
+	target.SetInfluenceMatrix(base, target.Revision)
+	if target.SparseInfluence != nil || !reflect.DeepEqual(base, target.InfluenceMatrix) {
+		t.Fatalf("the matrix is not restored over the sparse influence")
+	}
+}
+
+func TestInfluenceDegrees(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+		target.SparseInfluence = nil
+	}()
+	if inDeg, outDeg := target.InfluenceDegrees(); inDeg != nil || outDeg != nil {
+		t.Fatalf("got degrees without analyzed influence")
+	}
+	produce := target.SyscallMap["test$produce_inout_res"].ID
+	update := target.SyscallMap["test$update_inout_res"].ID
+	consume := target.SyscallMap["test$consume_inout_res"].ID
+	for _, sparse := range []bool{false, true} {
+		if sparse {
+			target.AnalyzeSparseInfluence(map[int]bool{produce: true, update: true, consume: true})
+		} else {
+			target.AnalyzeStaticInfluence()
+		}
+		inDeg, outDeg := target.InfluenceDegrees()
+		edges := 0
+		for id := range target.Syscalls {
+			edges += outDeg[id]
+			edges -= inDeg[id]
+		}
+		if edges != 0 {
+			t.Errorf("sparse=%v: in and out degrees don't match", sparse)
+		}
+		// See TestStaticInfluenceInOut.
+		if outDeg[produce] < 2 || inDeg[produce] != 0 || inDeg[consume] < 2 || outDeg[consume] != 0 {
+			t.Errorf("sparse=%v: bad degrees: produce %v/%v, consume %v/%v", sparse,
+				inDeg[produce], outDeg[produce], inDeg[consume], outDeg[consume])
+		}
+		if sparse && (outDeg[produce] != 2 || inDeg[consume] != 2 || inDeg[update] != 1 || outDeg[update] != 1) {
+			t.Errorf("bad sparse degrees: %v/%v, %v/%v, %v/%v", inDeg[produce], outDeg[produce],
+				inDeg[update], outDeg[update], inDeg[consume], outDeg[consume])
+		}
+	}
+}
+
+func TestCheckInfluence(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+		target.influenceDirty.Store(0)
+	}()
+	p, err := target.Deserialize([]byte("r0 = test$produce_inout_res()\n"+
+		"test$update_inout_res(&(0x7f0000000000)=r0)\n"+
+		"test$consume_inout_res(r0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := target.CheckInfluence(p); err != nil {
+		t.Fatalf("no influence: %v", err)
+	}
+	target.AnalyzeStaticInfluence()
+	if err := target.CheckInfluence(p); err != nil {
+		t.Fatalf("static influence: %v", err)
+	}
+	// Simulate a matrix of an older revision that has fewer syscalls, so that the ID of one of the calls
+	// of the program is out of range.
+	produce, consume := p.Calls[0].Meta.ID, p.Calls[2].Meta.ID
+	size := produce
+	for _, c := range p.Calls {
+		if c.Meta.ID > size {
+			size = c.Meta.ID
+		}
+	}
+	stale := target.CloneInfluenceMatrix()[:size]
+	for i := range stale {
+		stale[i] = stale[i][:size]
+	}
+	target.SetInfluenceMatrix(stale, target.Revision)
+	if err := target.CheckInfluence(p); err == nil {
+		t.Fatalf("no error for a stale matrix")
+	}
+	if target.influences(produce, size) || target.addInfluence(size, consume) {
+		t.Fatalf("out of range influence edge")
+	}
+	if got := InfluenceDescendants(p, 0); len(got) != 0 {
+		t.Fatalf("got descendants %v with a stale matrix", got)
+	}
+	// Minimization falls back to the vanilla strategy instead of indexing the matrix out of range.
+	pred := func(p1 *Prog, callIndex, minimizeType int) bool {
+		return len(p1.Calls) > callIndex && p1.Calls[callIndex].Meta.ID == consume
+	}
+	p1, ci, err := Minimize(p, 2, false, pred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Calls) != 1 || p1.Calls[ci].Meta.ID != consume {
+		t.Fatalf("bad minimized program:\n%s", p1.Serialize())
+	}
+}
+
+func TestCheckInfluenceRevision(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+		target.influenceRevision = ""
+		target.influenceDirty.Store(0)
+	}()
+	p, err := target.Deserialize([]byte("r0 = test$produce_inout_res()\n"+
+		"test$update_inout_res(&(0x7f0000000000)=r0)\n"+
+		"test$consume_inout_res(r0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.AnalyzeStaticInfluence()
+	base := target.CloneInfluenceMatrix()
+	// Simulate a matrix of another revision where syscall IDs are permuted, but stay in range:
+	// the producer and the consumer swap their IDs.
+	produce, consume := p.Calls[0].Meta.ID, p.Calls[2].Meta.ID
+	perm := func(id int) int {
+		switch id {
+		case produce:
+			return consume
+		case consume:
+			return produce
+		}
+		return id
+	}
+	permuted := make([][]uint8, len(base))
+	for i := range base {
+		permuted[perm(i)] = make([]uint8, len(base[i]))
+		for j := range base[i] {
+			permuted[perm(i)][perm(j)] = base[i][j]
+		}
+	}
+	target.SetInfluenceMatrix(permuted, "old"+target.Revision)
+	if err := target.CheckInfluence(p); err == nil || !strings.Contains(err.Error(), "revision") {
+		t.Fatalf("got %v for a matrix of another revision", err)
+	}
+	if target.addInfluence(produce, consume) {
+		t.Fatalf("learning updated a matrix of another revision")
+	}
+	if got := InfluenceDescendants(p, 0); len(got) != 0 {
+		t.Fatalf("got descendants %v with a matrix of another revision", got)
+	}
+	// Minimization falls back to the vanilla strategy instead of using the wrong rows.
+	pred := func(p1 *Prog, callIndex, minimizeType int) bool {
+		return len(p1.Calls) > callIndex && p1.Calls[callIndex].Meta.ID == consume
+	}
+	p1, ci, err := Minimize(p, 2, false, pred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Calls) != 1 || p1.Calls[ci].Meta.ID != consume {
+		t.Fatalf("bad minimized program:\n%s", p1.Serialize())
+	}
+	target.SetInfluenceMatrix(base, target.Revision)
+	if err := target.CheckInfluence(p); err != nil {
+		t.Fatalf("matrix of the current revision: %v", err)
+	}
+}
+
+func TestInfluenceEdgeReasons(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.InfluenceMatrix = nil
+		target.SparseInfluence = nil
+		target.influenceUsages = nil
+	}()
+	reasons := func(src, dest string) []string {
+		return target.InfluenceEdgeReasons(target.SyscallMap[src].ID, target.SyscallMap[dest].ID)
+	}
+	if got := reasons("test$produce_common", "test$consume_common"); got != nil {
+		t.Fatalf("got reasons %v without analyzed influence", got)
+	}
+	tests := []struct {
+		src, dest string
+		want      []string
+	}{
+		{"test$produce_common", "test$consume_common", []string{"common"}},
+		{"test$produce_subtype_of_common", "test$consume_common", []string{"subtype_of_common->common"}},
+		{"test$produce_common", "test$consume_subtype_of_common", []string{"common->subtype_of_common"}},
+		{"test$update_inout_res", "test$consume_inout_res", []string{"inout_res"}},
+		{"test$consume_common", "test$produce_common", nil},
+		{"test$update_inout_res", "test$update_inout_res", nil},
+	}
+	for _, sparse := range []bool{false, true} {
+		if sparse {
+			target.AnalyzeSparseInfluence(nil)
+		} else {
+			target.AnalyzeStaticInfluence()
+		}
+		for _, test := range tests {
+			got := reasons(test.src, test.dest)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("sparse=%v: %v -> %v: got %q, want %q", sparse, test.src, test.dest, got, test.want)
+			}
+			// Every static edge has a reason.
+			edge := target.influences(target.SyscallMap[test.src].ID, target.SyscallMap[test.dest].ID)
+			if edge != (got != nil) {
+				t.Errorf("sparse=%v: %v -> %v: edge %v, reasons %q", sparse, test.src, test.dest, edge, got)
+			}
+		}
+	}
+}
+
+func TestStaticInfluenceSubtypes(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	matrix := target.staticInfluence()
+	influences := func(src, dest string) bool {
+		return matrix[target.SyscallMap[src].ID][target.SyscallMap[dest].ID] == 1
+	}
+	tests := []struct {
+		src, dest string
+		want      bool
+	}{
+		{"test$produce_common", "test$consume_common", true},
+		{"test$also_produce_common", "test$consume_common", true},
+		// A subtype can be passed where the supertype is expected.
+		{"test$produce_subtype_of_common", "test$consume_common", true},
+		// And the supertype can be passed where a subtype is expected.
+		{"test$produce_common", "test$consume_subtype_of_common", true},
+		{"test$produce_subtype_of_common", "test$consume_subtype_of_common", true},
+		{"test$consume_common", "test$produce_common", false},
+		{"test$produce_common", "test$also_produce_common", false},
+	}
+	for _, test := range tests {
+		if got := influences(test.src, test.dest); got != test.want {
+			t.Errorf("%v -> %v: got %v, want %v", test.src, test.dest, got, test.want)
+		}
+	}
+}
+
+func TestStaticInfluenceInOut(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	matrix := target.staticInfluence()
+	influences := func(src, dest string) bool {
+		return matrix[target.SyscallMap[src].ID][target.SyscallMap[dest].ID] == 1
+	}
+	tests := []struct {
+		src, dest string
+		want      bool
+	}{
+		{"test$produce_inout_res", "test$update_inout_res", true},
+		{"test$produce_inout_res", "test$consume_inout_res", true},
+		// An inout use produces the resource for subsequent consumers.
+		{"test$update_inout_res", "test$consume_inout_res", true},
+		{"test$update_inout_res", "test$update_inout_res", false},
+		{"test$update_inout_res", "test$produce_inout_res", false},
+		{"test$consume_inout_res", "test$update_inout_res", false},
+	}
+	for _, test := range tests {
+		if got := influences(test.src, test.dest); got != test.want {
+			t.Errorf("%v -> %v: got %v, want %v", test.src, test.dest, got, test.want)
+		}
+	}
+}
+
+func TestDumpSyscallIndex(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	buf := new(bytes.Buffer)
+	if err := target.DumpSyscallIndex(buf); err != nil {
+		t.Fatal(err)
+	}
+	index := new(SyscallIndex)
+	if err := json.Unmarshal(buf.Bytes(), index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Revision != target.Revision || index.OS != target.OS || index.Arch != target.Arch {
+		t.Fatalf("bad index header: %v/%v/%v", index.OS, index.Arch, index.Revision)
+	}
+	if len(index.Syscalls) != len(target.Syscalls) {
+		t.Fatalf("got %v syscalls, want %v", len(index.Syscalls), len(target.Syscalls))
+	}
+	for _, c := range target.Syscalls {
+		if index.Syscalls[c.ID] != c.Name {
+			t.Fatalf("syscall %v: got %q, want %q", c.ID, index.Syscalls[c.ID], c.Name)
+		}
+	}
+}
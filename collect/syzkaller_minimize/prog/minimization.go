@@ -5,37 +5,263 @@ package prog
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"fmt"
 	"reflect"
 )
 
+// MinimizeOptions tunes the minimization process.
+// The zero value corresponds to the default behavior of Minimize.
+type MinimizeOptions struct {
+	// MaxRemovalsPerPhase limits the number of calls a single call removal phase
+	// may drop before yielding to the next phase. The phases are then cycled
+	// until none of them makes progress. Zero means no limit.
+	MaxRemovalsPerPhase int
+	// DisableCalls makes call removal first try to disable a call by replacing it with a call
+	// without arguments that produces the same resource (see disableCall). Unlike removal, this
+	// does not break resources produced by the call, so it may succeed where removal fails.
+	DisableCalls bool
+	// NoInfluence disables the influence-guided call removal and falls back to the vanilla
+	// strategy (unrelated calls removal followed by one-by-one removal of every call).
+	// It does not need target.InfluenceMatrix and serves as a baseline for comparison.
+	NoInfluence bool
+	// KeepCalls holds names of syscalls that call removal never deletes (e.g. essential
+	// setup of a multi-stage bug), in addition to the preserved call.
+	KeepCalls map[string]bool
+	// ArgsOnly skips call removal altogether and only minimizes arguments of the calls,
+	// e.g. to study argument minimization in isolation. The call index is not changed.
+	ArgsOnly bool
+	// MaxCalls stops call removal as soon as the program has at most that many calls and skips
+	// argument and props minimization, for when a small enough program suffices. Zero means no limit.
+	MaxCalls int
+	// OnReduce, if set, is invoked whenever a simplification is committed, i.e. the predicate
+	// accepted a candidate, with kind set to one of Reduce* constants. p is owned by the minimizer
+	// and may be modified afterwards, so it must be cloned to be retained.
+	OnReduce func(p *Prog, callIndex int, kind string)
+	// IgnoreInfluencers holds names of syscalls whose influence edges are not followed when
+	// the influence decides which front calls to keep (e.g. generic setup like mmap that
+	// influences almost everything). This is risky: if such a call is really needed, only
+	// the predicate protects it from removal.
+	IgnoreInfluencers map[string]bool
+	// Stop, if set, interrupts minimization when closed: all remaining candidates are rejected
+	// without invoking the predicate, so Minimize quickly returns the best program found so far.
+	Stop <-chan struct{}
+	// CompValues holds operands of comparisons observed while executing the program (see CompMap).
+	// Integer arguments with such values are likely checked by the kernel, so resetting them
+	// is tried only after all other arguments of the call are minimized.
+	CompValues map[uint64]bool
+	// NoPostRemove skips the bulk removal of calls after the preserved call (see removePostCalls),
+	// for programs where later calls affect the preserved one and the bulk removal is doomed.
+	// Such calls are still removed one-by-one.
+	NoPostRemove bool
+	// Stable disables dynamic influence learning (see Influence_Learning_Enable) for this minimization,
+	// so that removal decisions depend only on the program and the predicate verdicts and not
+	// on coverage hashes of rejected candidates. Identical input then minimizes to identical output.
+	Stable bool
+	// ArgsFocus restricts argument and props minimization to the preserved call, arguments of
+	// other calls are left untouched. Combined with ArgsOnly it quickly cleans up just the
+	// interesting call. It is ignored if there is no preserved call (callIndex0 is -1).
+	ArgsFocus bool
+	// CallName, if set, is the expected name of the preserved call. Minimize fails early if the call
+	// at callIndex0 is a different one, e.g. because the index was derived from a wrong file name,
+	// instead of minimizing the wrong call and failing the final consistency check.
+	CallName string
+	// FreshResources enables a call removal phase that makes consumers of resources produced far
+	// upstream use a fresh resource created right before them, so that the upstream producers
+	// can be removed (see replaceWithFreshResources).
+	FreshResources bool
+	// OnRetain, if set, is invoked for every call that influence-guided front call removal keeps
+	// because it transitively influences the preserved call. path holds indices of calls in p
+	// from the kept call to the preserved one, each call influences the next one.
+	// p is owned by the minimizer, see OnReduce.
+	OnRetain func(p *Prog, path []int)
+	// DetectFlaky remembers verdicts of the predicate for every candidate, so that candidates
+	// that are tried several times and get both verdicts are counted in MinimizeStats.Flaky.
+	// A flaky predicate (e.g. because of an unstable executor) makes minimization results inconsistent.
+	DetectFlaky bool
+
+	// stats is the MinimizeStats of the current minimization.
+	stats *MinimizeStats
+}
+
+// MinimizeStats counts predicate invocations of a minimization by what was being simplified.
+type MinimizeStats struct {
+	Total int // all predicate invocations
+	Calls int // call removal
+	Args  int // argument simplification
+	Props int // call props simplification
+	// Disables counts call disabling with MinimizeOptions.DisableCalls.
+	Disables int
+	// InfluenceUpdates is the number of influence edges added by dynamic learning
+	// (see Influence_Learning_Enable).
+	InfluenceUpdates int
+	// Flaky is the number of candidates that got both true and false verdicts, see DetectFlaky.
+	Flaky int
+}
+
+// Add adds stats of another minimization, e.g. of a subsequent pass.
+func (stats *MinimizeStats) Add(other MinimizeStats) {
+	stats.Total += other.Total
+	stats.Calls += other.Calls
+	stats.Args += other.Args
+	stats.Props += other.Props
+	stats.Disables += other.Disables
+	stats.InfluenceUpdates += other.InfluenceUpdates
+	stats.Flaky += other.Flaky
+}
+
+func (stats *MinimizeStats) count(minimizeType int) {
+	stats.Total++
+	switch minimizeType {
+	case 1:
+		stats.Calls++
+	case 2:
+		stats.Args++
+	case 3:
+		stats.Props++
+	case 4:
+		stats.Disables++
+	}
+}
+
+func (opts *MinimizeOptions) stopped() bool {
+	select {
+	case <-opts.Stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// learning returns true if rejected call removals are used for dynamic influence learning.
+func (opts *MinimizeOptions) learning() bool {
+	return Influence_Learning_Enable && !opts.Stable
+}
+
+// Kinds of committed simplifications reported to MinimizeOptions.OnReduce.
+const (
+	ReduceRemoveCall = "remove_call"
+	ReduceArg        = "arg"
+	ReduceProps      = "props"
+	ReduceDisable    = "disable_call"
+)
+
+func reduceKind(minimizeType int) string {
+	switch minimizeType {
+	case 1:
+		return ReduceRemoveCall
+	case 2:
+		return ReduceArg
+	case 3:
+		return ReduceProps
+	case 4:
+		return ReduceDisable
+	default:
+		panic(fmt.Sprintf("unknown minimization type %v", minimizeType))
+	}
+}
+
+func (opts *MinimizeOptions) keepCall(c *Call) bool {
+	return opts.KeepCalls[c.Meta.Name]
+}
+
+func (opts *MinimizeOptions) maxCallsReached(p *Prog) bool {
+	return opts.MaxCalls > 0 && len(p.Calls) <= opts.MaxCalls
+}
+
 // Minimize minimizes program p into an equivalent program using the equivalence
 // predicate pred. It iteratively generates simpler programs and asks pred
 // whether it is equal to the original program or not. If it is equivalent then
 // the simplification attempt is committed and the process continues.
-func Minimize(p0 *Prog, callIndex0 int, crash bool, pred0 func(*Prog, int, int) bool) (*Prog, int) {
+// The last argument of pred tells what is being simplified: 1 for call removal, 2 for call arguments,
+// 3 for call props (fault injection, async, rerun) and 4 for call disabling (see DisableCalls).
+// An error is returned if callIndex0 does not refer to a call of p0 or if the call
+// was lost during minimization, in such case the returned program should not be used.
+func Minimize(p0 *Prog, callIndex0 int, crash bool, pred0 func(*Prog, int, int) bool) (*Prog, int, error) {
+	return MinimizeWithOptions(p0, callIndex0, crash, pred0, MinimizeOptions{})
+}
+
+// MinimizeWithOptions is like Minimize, but allows to tune the process with opts.
+func MinimizeWithOptions(p0 *Prog, callIndex0 int, crash bool, pred0 func(*Prog, int, int) bool,
+	opts MinimizeOptions) (*Prog, int, error) {
+	p, callIndex, _, err := MinimizeWithStats(p0, callIndex0, crash, pred0, opts)
+	return p, callIndex, err
+}
+
+// MinimizeWithStats is like MinimizeWithOptions, but also returns statistics of the minimization,
+// so that callers don't need to count predicate invocations themselves.
+func MinimizeWithStats(p0 *Prog, callIndex0 int, crash bool, pred0 func(*Prog, int, int) bool,
+	opts MinimizeOptions) (*Prog, int, MinimizeStats, error) {
+	var stats MinimizeStats
+	opts.stats = &stats
+	p, callIndex, err := minimize(p0, callIndex0, crash, pred0, &opts)
+	return p, callIndex, stats, err
+}
+
+func minimize(p0 *Prog, callIndex0 int, crash bool, pred0 func(*Prog, int, int) bool,
+	opts *MinimizeOptions) (*Prog, int, error) {
+	var verdicts *flakyVerdicts
+	if opts.DetectFlaky {
+		verdicts = newFlakyVerdicts()
+	}
 	pred := func(p *Prog, callIndex int, minimize_type_flag int) bool {
+		if opts.stopped() {
+			return false
+		}
+		opts.stats.count(minimize_type_flag)
 		p.sanitizeFix()
+		// Validation is enabled only in tests and with Debug, since it dominates the cost of
+		// candidate construction: BenchmarkMinimizeValidate shows ~0.2ms per candidate on a
+		// 30-call linux/amd64 program, which makes Minimize ~7x slower.
 		p.debugValidate()
-		return pred0(p, callIndex, minimize_type_flag)
+		// Call removals are used for dynamic influence learning, so ask for per-call coverage hashes.
+		p.Influence_Hash_NeedUpdate = opts.learning() && minimize_type_flag == 1
+		res := pred0(p, callIndex, minimize_type_flag)
+		p.Influence_Hash_NeedUpdate = false
+		if verdicts != nil && verdicts.record(p, callIndex, res) {
+			opts.stats.Flaky++
+		}
+		if res && opts.OnReduce != nil {
+			opts.OnReduce(p, callIndex, reduceKind(minimize_type_flag))
+		}
+		return res
 	}
 	name0 := ""
 	if callIndex0 != -1 {
 		if callIndex0 < 0 || callIndex0 >= len(p0.Calls) {
-			panic("bad call index")
+			return p0, callIndex0, fmt.Errorf("bad call index %v for a program with %v calls",
+				callIndex0, len(p0.Calls))
 		}
 		name0 = p0.Calls[callIndex0].Meta.Name
+		if opts.CallName != "" && name0 != opts.CallName {
+			return p0, callIndex0, fmt.Errorf("bad call index %v: the call is %v, expected %v",
+				callIndex0, name0, opts.CallName)
+		}
+	}
+	if isTriviallyMinimal(p0, callIndex0) {
+		// Nothing can be reduced, so don't bother cloning the program and running the predicate.
+		return p0, callIndex0, nil
 	}
 
 	// Try to remove all calls except the last one one-by-one.
-	p0, callIndex0 = removeCalls(p0, callIndex0, crash, pred)
+	if !opts.ArgsOnly {
+		var err error
+		p0, callIndex0, err = removeCalls(p0, callIndex0, crash, pred, opts)
+		if err != nil {
+			return p0, callIndex0, err
+		}
+	}
 
 	// Try to reset all call props to their default values.
-	// p0 = resetCallProps(p0, callIndex0, pred)
+	// Props are kept only if the predicate needs them (e.g. a bug that requires async execution).
+	if !opts.ArgsOnly && !opts.maxCallsReached(p0) {
+		p0 = resetCallProps(p0, callIndex0, pred)
+	}
 
 	// Try to minimize individual calls.
-	for i := 0; i < len(p0.Calls); i++ {
-		if p0.Calls[i].Meta.Attrs.NoMinimize {
+	// Argument minimization doesn't change the number of calls, so with MaxCalls it's skipped altogether.
+	for i := 0; i < len(p0.Calls) && !opts.maxCallsReached(p0); i++ {
+		if p0.Calls[i].Meta.Attrs.NoMinimize || opts.ArgsFocus && callIndex0 != -1 && i != callIndex0 {
 			continue
 		}
 		ctx := &minimizeArgsCtx{
@@ -45,6 +271,7 @@ func Minimize(p0 *Prog, callIndex0 int, crash bool, pred0 func(*Prog, int, int)
 			crash:      crash,
 			pred:       pred,
 			triedPaths: make(map[string]bool),
+			compValues: opts.CompValues,
 		}
 	again:
 		ctx.p = p0.Clone()
@@ -54,44 +281,238 @@ func Minimize(p0 *Prog, callIndex0 int, crash bool, pred0 func(*Prog, int, int)
 				goto again
 			}
 		}
-		// p0 = minimizeCallProps(p0, i, callIndex0, pred)
+		if ctx.deferred {
+			// Now try the arguments that were deferred because of comparisons.
+			ctx.compValues, ctx.deferred = nil, false
+			goto again
+		}
+		if !opts.ArgsOnly {
+			p0 = minimizeCallProps(p0, i, callIndex0, pred)
+		}
 	}
 
 	if callIndex0 != -1 {
-		if callIndex0 < 0 || callIndex0 >= len(p0.Calls) || name0 != p0.Calls[callIndex0].Meta.Name {
-			panic(fmt.Sprintf("bad call index after minimization: ncalls=%v index=%v call=%v/%v",
-				len(p0.Calls), callIndex0, name0, p0.Calls[callIndex0].Meta.Name))
+		if callIndex0 < 0 || callIndex0 >= len(p0.Calls) {
+			return p0, callIndex0, fmt.Errorf("bad call index after minimization: ncalls=%v index=%v call=%v",
+				len(p0.Calls), callIndex0, name0)
+		}
+		if name := p0.Calls[callIndex0].Meta.Name; name != name0 {
+			return p0, callIndex0, fmt.Errorf("bad call index after minimization: ncalls=%v index=%v call=%v/%v",
+				len(p0.Calls), callIndex0, name0, name)
+		}
+	}
+	return p0, callIndex0, nil
+}
+
+// MinimizeSequence minimizes a sequence of programs that reproduce a bug only when they are executed
+// one after another, e.g. because an earlier program leaves kernel state that a later one depends on.
+// pred executes the whole sequence in order and returns whether call targetCall of progs[targetProg]
+// still behaves as in the original sequence. MinimizeSequence assumes that programs are executed
+// in the given order in the same environment without resetting it in between, so that state
+// carries over from one program to the next (this is what pred has to provide), and that a program
+// affects later programs only through such state. Programs are never reordered or merged.
+// First whole programs other than the target one are removed one-by-one from the end, then each
+// surviving program is minimized with Minimize while the other programs are fixed. Only the target
+// call is preserved, programs other than the target one may lose all their calls, in such case
+// they are dropped. The returned programs are new, progs are not modified.
+// Returns the minimized sequence and the index of the target program and call in it.
+func MinimizeSequence(progs []*Prog, targetProg, targetCall int,
+	pred func(progs []*Prog, targetProg, targetCall int) bool) ([]*Prog, int, int, error) {
+	if targetProg < 0 || targetProg >= len(progs) {
+		return progs, targetProg, targetCall, fmt.Errorf("bad target program index %v for a sequence of %v programs",
+			targetProg, len(progs))
+	}
+	if targetCall < 0 || targetCall >= len(progs[targetProg].Calls) {
+		return progs, targetProg, targetCall, fmt.Errorf("bad call index %v for a program with %v calls",
+			targetCall, len(progs[targetProg].Calls))
+	}
+	seq := make([]*Prog, len(progs))
+	for i, p := range progs {
+		seq[i] = p.Clone()
+	}
+
+	// Try to remove whole programs.
+	for i := len(seq) - 1; i >= 0; i-- {
+		if i == targetProg {
+			continue
+		}
+		seq1 := removeProg(seq, i)
+		targetProg1 := targetProg
+		if i < targetProg {
+			targetProg1--
+		}
+		if pred(seq1, targetProg1, targetCall) {
+			seq, targetProg = seq1, targetProg1
+		}
+	}
+
+	// Try to minimize the surviving programs individually.
+	for i := 0; i < len(seq); i++ {
+		callIndex0 := -1
+		if i == targetProg {
+			callIndex0 = targetCall
+		}
+		p, callIndex, err := Minimize(seq[i], callIndex0, false, func(p *Prog, callIndex, _ int) bool {
+			seq1 := append([]*Prog{}, seq...)
+			seq1[i] = p
+			if i == targetProg {
+				return pred(seq1, targetProg, callIndex)
+			}
+			return pred(seq1, targetProg, targetCall)
+		})
+		if err != nil {
+			return seq, targetProg, targetCall, fmt.Errorf("program %v: %w", i, err)
+		}
+		seq[i] = p
+		if i == targetProg {
+			targetCall = callIndex
 		}
 	}
-	return p0, callIndex0
+
+	// Executing an empty program is a no-op, and the predicate accepted the sequence with it.
+	for i := len(seq) - 1; i >= 0; i-- {
+		if i != targetProg && len(seq[i].Calls) == 0 {
+			seq = removeProg(seq, i)
+			if i < targetProg {
+				targetProg--
+			}
+		}
+	}
+	return seq, targetProg, targetCall, nil
+}
+
+func removeProg(progs []*Prog, idx int) []*Prog {
+	res := make([]*Prog, 0, len(progs)-1)
+	res = append(res, progs[:idx]...)
+	return append(res, progs[idx+1:]...)
 }
 
-func removeCalls(p0 *Prog, callIndex0 int, crash bool, pred func(*Prog, int, int) bool) (*Prog, int) {
-	// call-level optimization
-	remove_post_ids := []int{}
+// flakyVerdicts remembers predicate verdicts of candidates by hash of the serialized program.
+type flakyVerdicts struct {
+	seen map[flakyKey]uint8 // bit mask of flakyTrue and flakyFalse
+}
+
+type flakyKey struct {
+	sig       [sha1.Size]byte
+	callIndex int
+}
+
+const (
+	flakyTrue = 1 << iota
+	flakyFalse
+)
+
+func newFlakyVerdicts() *flakyVerdicts {
+	return &flakyVerdicts{seen: make(map[flakyKey]uint8)}
+}
+
+// record returns true if the candidate got the opposite verdict before, but only the first time,
+// so that every flaky candidate is counted once.
+func (v *flakyVerdicts) record(p *Prog, callIndex int, res bool) bool {
+	key := flakyKey{sha1.Sum(p.Serialize()), callIndex}
+	mask := uint8(flakyFalse)
+	if res {
+		mask = flakyTrue
+	}
+	old := v.seen[key]
+	v.seen[key] = old | mask
+	return old|mask == flakyTrue|flakyFalse && old != old|mask
+}
+
+// isTriviallyMinimal returns true if p consists only of the preserved call
+// with default props and all arguments set to their default values.
+func isTriviallyMinimal(p *Prog, callIndex int) bool {
+	if len(p.Calls) != 1 || callIndex != 0 {
+		return false
+	}
+	c := p.Calls[0]
+	if !reflect.DeepEqual(c.Props, CallProps{}) {
+		return false
+	}
+	for _, arg := range c.Args {
+		if !isDefault(arg) {
+			return false
+		}
+	}
+	return true
+}
+
+func removeCalls(p0 *Prog, callIndex0 int, crash bool, pred func(*Prog, int, int) bool,
+	opts *MinimizeOptions) (*Prog, int, error) {
+	// Nothing to remove if the preserved call is the only one.
+	if len(p0.Calls) == 1 && callIndex0 == 0 {
+		return p0, callIndex0, nil
+	}
+	// Influence is not available if neither AnalyzeStaticInfluence nor AnalyzeSparseInfluence was called
+	// for the target (e.g. in tests and tools that don't care about influence), use the vanilla strategy in such case.
+	// The same applies if the influence matrix doesn't match syscall IDs of the program (see CheckInfluence).
+	if opts.NoInfluence || !p0.Target.hasInfluence() || p0.Target.CheckInfluence(p0) != nil {
+		return runCallRemovalPhases(p0, callIndex0, pred, opts, vanillaCallRemovalPhases)
+	}
+	return runCallRemovalPhases(p0, callIndex0, pred, opts, callRemovalPhases)
+}
+
+// removeFrontCalls tries to remove all calls before the target call that don't influence it at once.
+func removeFrontCalls(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool, opts *MinimizeOptions) (
+	*Prog, int, error) {
+	var ids []int
+	for _, i := range frontRemovalCandidates(p0, callIndex0, opts) {
+		if !opts.keepCall(p0.Calls[i]) {
+			ids = append(ids, i)
+		}
+	}
+	if limit := opts.MaxRemovalsPerPhase; limit > 0 && len(ids) > limit {
+		ids = ids[len(ids)-limit:]
+	}
+	if len(ids) == 0 {
+		return p0, callIndex0, nil
+	}
+	p, callIndex, err := removeCallSet(p0, callIndex0, ids)
+	if err != nil {
+		return p0, callIndex0, err
+	}
+	if !pred(p, callIndex, 1) {
+		return p0, callIndex0, nil
+	}
+	return p, callIndex, nil
+}
+
+// frontRemovalCandidates returns indices of calls before callIndex0 that don't transitively
+// influence it according to the target influence, i.e. the front calls that may be removed.
+// Edges from syscalls in opts.IgnoreInfluencers are not followed, so such calls are candidates
+// even if they do influence the call.
+func frontRemovalCandidates(p0 *Prog, callIndex0 int, opts *MinimizeOptions) []int {
 	remove_front_ids := []int{}
 	queue := NewIntQueue()
 	queue_map := make(map[int]bool)
 	influence_map := make(map[int]bool)
-	if callIndex0 >= 0 && callIndex0+2 < len(p0.Calls) {
-		for i := callIndex0 + 1; i < len(p0.Calls); i++ {
-			remove_post_ids = append(remove_post_ids, i)
-		}
+	// retained_by holds the call that caused each call to be added to influence_map.
+	retained_by := make(map[int]int)
+	influences := func(src, dest int) bool {
+		return !opts.IgnoreInfluencers[p0.Calls[src].Meta.Name] &&
+			p0.Target.influences(p0.Calls[src].Meta.ID, p0.Calls[dest].Meta.ID)
 	}
 
 	for i := callIndex0 - 1; i >= 0; i-- {
-		if p0.Target.InfluenceMatrix[p0.Calls[i].Meta.ID][p0.Calls[callIndex0].Meta.ID] == 1 { // be influenced calls
+		if influences(i, callIndex0) { // be influenced calls
 			queue.Enqueue(i)
-			influence_map[i] = true
+			if !influence_map[i] {
+				influence_map[i] = true
+				retained_by[i] = callIndex0
+			}
 			queue_map[i] = true
 
 			for queue.Length() > 0 {
 				id, _ := queue.Dequeue()
 				for j := id - 1; j >= 0; j-- {
-					if p0.Target.InfluenceMatrix[p0.Calls[j].Meta.ID][p0.Calls[id].Meta.ID] == 1 {
-						influence_map[j] = true
+					if influences(j, id) {
+						if !influence_map[j] {
+							influence_map[j] = true
+							retained_by[j] = id
+						}
 						if queue_map[j] == false {
 							queue.Enqueue(j)
+							queue_map[j] = true
 						}
 					}
 				}
@@ -101,42 +522,362 @@ func removeCalls(p0 *Prog, callIndex0 int, crash bool, pred func(*Prog, int, int
 	for i := 0; i < callIndex0; i++ {
 		if influence_map[i] == false {
 			remove_front_ids = append(remove_front_ids, i)
+		} else if opts.OnRetain != nil {
+			path := []int{i}
+			for id := i; id != callIndex0; {
+				id = retained_by[id]
+				path = append(path, id)
+			}
+			opts.OnRetain(p0, path)
 		}
 	}
+	return remove_front_ids
+}
 
-	// remove post calls
-	if len(remove_post_ids) > 0 {
-		p := p0.Clone()
-		for index, _ := range remove_post_ids { //remove back
-			p.RemoveCall(remove_post_ids[len(remove_post_ids)-1-index]) //from back to front
+// callRemovalPhase tries to remove calls from p0 and returns the resulting program and call index.
+// A phase must not remove more than opts.MaxRemovalsPerPhase calls, unless it's 0.
+type callRemovalPhase func(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool, opts *MinimizeOptions) (
+	*Prog, int, error)
+
+var callRemovalPhases = []callRemovalPhase{
+	collapseDuplicateCalls,
+	removePostCalls,
+	removeFrontCalls,
+	removeUnrelatedCallsPhase,
+	removeCallsOneByOne,
+	replaceWithFreshResources,
+}
+
+// vanillaCallRemovalPhases is the upstream call removal strategy used with MinimizeOptions.NoInfluence.
+// replaceWithFreshResources does not need influence, so it's available here as well.
+var vanillaCallRemovalPhases = []callRemovalPhase{
+	removeUnrelatedCallsPhase,
+	removeCallsOneByOne,
+	replaceWithFreshResources,
+}
+
+// runCallRemovalPhases runs each phase once if opts.MaxRemovalsPerPhase is 0. Otherwise the phases
+// are cycled, each removing at most that many calls per turn, until a whole cycle makes no progress.
+func runCallRemovalPhases(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool, opts *MinimizeOptions,
+	phases []callRemovalPhase) (*Prog, int, error) {
+	for progress := true; progress; {
+		progress = false
+		for i, phase := range phases {
+			if opts.maxCallsReached(p0) {
+				return p0, callIndex0, nil
+			}
+			ncalls := len(p0.Calls)
+			p, callIndex, err := phase(p0, callIndex0, pred, opts)
+			if err != nil {
+				return p0, callIndex0, err
+			}
+			if callIndex0 != -1 && (callIndex < 0 || callIndex >= len(p.Calls)) {
+				return p0, callIndex0, fmt.Errorf("call removal phase %v moved call index %v out of range [0, %v)",
+					i, callIndex, len(p.Calls))
+			}
+			p0, callIndex0 = p, callIndex
+			if len(p0.Calls) != ncalls {
+				progress = true
+			}
+		}
+		if opts.MaxRemovalsPerPhase <= 0 {
+			break
 		}
+	}
+	return p0, callIndex0, nil
+}
 
-		if pred(p, callIndex0, 1) {
-			p0 = p
+// collapseDuplicateCalls tries to remove repeated calls from runs of adjacent identical calls
+// (same syscall, arguments and props), keeping one call of each run. Generated programs often
+// contain such runs, and removing a whole run at once is cheap compared to one-by-one removal.
+func collapseDuplicateCalls(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool, opts *MinimizeOptions) (
+	*Prog, int, error) {
+	calls := serializeCalls(p0)
+	removed := 0
+	// Runs are tried from back to front, so that indices of the preceding runs stay valid.
+	for end := len(calls); end > 0; {
+		start := end - 1
+		for start > 0 && calls[start-1] == calls[end-1] {
+			start--
+		}
+		// The preserved call is the one kept if it's in the run.
+		keep := start
+		if callIndex0 >= start && callIndex0 < end {
+			keep = callIndex0
+		}
+		var ids []int
+		for i := start; i < end; i++ {
+			if i != keep && !opts.keepCall(p0.Calls[i]) {
+				ids = append(ids, i)
+			}
+		}
+		end = start
+		if limit := opts.MaxRemovalsPerPhase; limit > 0 && removed+len(ids) > limit {
+			ids = ids[:limit-removed]
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		p, callIndex, err := removeCallSet(p0, callIndex0, ids)
+		if err != nil {
+			return p0, callIndex0, err
+		}
+		if pred(p, callIndex, 1) {
+			p0, callIndex0 = p, callIndex
+			removed += len(ids)
+		}
+		if opts.maxCallsReached(p0) || opts.MaxRemovalsPerPhase > 0 && removed >= opts.MaxRemovalsPerPhase {
+			break
+		}
+	}
+	return p0, callIndex0, nil
+}
 
+// serializeCalls returns serialized calls of p. Calls that produce used resources
+// never serialize identically, since each of them defines a distinct variable.
+func serializeCalls(p *Prog) []string {
+	ctx := &serializer{
+		target: p.Target,
+		buf:    new(bytes.Buffer),
+		vars:   make(map[*ResultArg]int),
+	}
+	calls := make([]string, len(p.Calls))
+	for i, c := range p.Calls {
+		ctx.buf.Reset()
+		ctx.call(c)
+		calls[i] = ctx.buf.String()
+	}
+	return calls
+}
+
+// removePostCalls tries to remove all calls after the target call at once. Later calls usually
+// can't affect the target call, but async calls or shared kernel state make it possible.
+// So if the bulk removal is rejected, the calls are retried one-by-one from the end.
+func removePostCalls(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool, opts *MinimizeOptions) (
+	*Prog, int, error) {
+	if opts.NoPostRemove || callIndex0 < 0 || callIndex0+2 >= len(p0.Calls) {
+		return p0, callIndex0, nil
+	}
+	first := callIndex0 + 1
+	if limit := opts.MaxRemovalsPerPhase; limit > 0 && len(p0.Calls)-first > limit {
+		first = len(p0.Calls) - limit
+	}
+	var ids []int
+	for i := first; i < len(p0.Calls); i++ {
+		if !opts.keepCall(p0.Calls[i]) {
+			ids = append(ids, i)
 		}
 	}
-	// // remove front calls
-	// if len(remove_front_ids) > 0 {
-	// 	p := p0.Clone()
-	// 	for index, _ := range remove_front_ids { //remove front
-	// 		p.RemoveCall(remove_front_ids[len(remove_front_ids)-1-index]) //from back to front
-	// 	}
-	// 	callIndex := callIndex0 - len(remove_front_ids)
-	// 	if pred(p, callIndex, 1) {
-	// 		p0 = p
-	// 		callIndex0 = callIndex
-	// 	}
-	// }
+	if len(ids) == 0 {
+		return p0, callIndex0, nil
+	}
+	p, callIndex, err := removeCallSet(p0, callIndex0, ids)
+	if err != nil {
+		return p0, callIndex0, err
+	}
+	if pred(p, callIndex, 1) {
+		return p, callIndex, nil
+	}
+	for j := len(ids) - 1; j >= 0 && !opts.maxCallsReached(p0); j-- {
+		p, callIndex, err := removeCallSet(p0, callIndex0, []int{ids[j]})
+		if err != nil {
+			return p0, callIndex0, err
+		}
+		if pred(p, callIndex, 1) {
+			p0, callIndex0 = p, callIndex
+		}
+	}
+	return p0, callIndex0, nil
+}
+
+func removeUnrelatedCallsPhase(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool,
+	opts *MinimizeOptions) (*Prog, int, error) {
+	if callIndex0 == -1 {
+		return p0, callIndex0, nil
+	}
+	return removeUnrelatedCalls(p0, callIndex0, pred, opts)
+}
+
+// replaceWithFreshResources tries to repoint resource args of calls to a resource returned by a ctor call
+// with default arguments inserted right before the consumer. The original producer is removed then, along
+// with the calls that only produced resources for it. A candidate is committed only if it has fewer calls
+// than the program and the predicate holds. Only precise ctors that return the resource are used.
+func replaceWithFreshResources(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool, opts *MinimizeOptions) (
+	*Prog, int, error) {
+	if !opts.FreshResources {
+		return p0, callIndex0, nil
+	}
+	removed := 0
+	for ci := len(p0.Calls) - 1; ci >= 0; ci-- {
+		for k := 0; k < len(resourceInputs(p0.Calls[ci])); k++ {
+			if opts.maxCallsReached(p0) || opts.MaxRemovalsPerPhase > 0 && removed >= opts.MaxRemovalsPerPhase {
+				return p0, callIndex0, nil
+			}
+			p, callIndex, ci1 := freshResourceCandidate(p0, callIndex0, ci, k, opts)
+			if p == nil || !pred(p, callIndex, 1) {
+				continue
+			}
+			removed += len(p0.Calls) - len(p.Calls)
+			p0, callIndex0, ci = p, callIndex, ci1
+		}
+	}
+	return p0, callIndex0, nil
+}
 
+// freshResourceCandidate returns a copy of p0 where the k-th resource input of call ci uses a fresh
+// resource and its former producer chain is removed, along with the updated indices of the preserved
+// call and of call ci. Returns nil if there is no suitable ctor or the candidate is not shorter than p0.
+func freshResourceCandidate(p0 *Prog, callIndex0, ci, k int, opts *MinimizeOptions) (*Prog, int, int) {
+	p := p0.Clone()
+	c := p.Calls[ci]
+	a := resourceInputs(c)[k]
+	ctor := freshResourceCtor(p.Target, a.Type().(*ResourceType))
+	if ctor == nil {
+		return nil, 0, 0
+	}
+	var preserved *Call
 	if callIndex0 != -1 {
-		p0, callIndex0 = removeUnrelatedCalls(p0, callIndex0, pred)
+		preserved = p.Calls[callIndex0]
 	}
+	producer := resourceProducerCall(p, a.Res)
+	delete(a.Res.uses, a)
+	a.Res = ctor.Ret
+	ctor.Ret.uses = map[*ResultArg]bool{a: true}
+	p.insertBefore(c, []*Call{ctor})
+	removed := 0
+	for queue := []*Call{producer}; len(queue) != 0; queue = queue[1:] {
+		call := queue[0]
+		idx := callPosition(p, call)
+		if idx == -1 || call == preserved || opts.keepCall(call) ||
+			call.Ret != nil && producesUsedResource(call.Ret) {
+			continue
+		}
+		used := false
+		for _, arg := range call.Args {
+			used = used || producesUsedResource(arg)
+		}
+		if used {
+			continue
+		}
+		for _, in := range resourceInputs(call) {
+			queue = append(queue, resourceProducerCall(p, in.Res))
+		}
+		p.RemoveCall(idx)
+		removed++
+	}
+	if removed < 2 {
+		return nil, 0, 0
+	}
+	return p, callPosition(p, preserved), callPosition(p, c)
+}
+
+// resourceInputs returns resource args of c that refer to resources produced by other calls.
+func resourceInputs(c *Call) []*ResultArg {
+	var res []*ResultArg
+	ForeachArg(c, func(arg Arg, _ *ArgCtx) {
+		if a, ok := arg.(*ResultArg); ok && a.Res != nil {
+			res = append(res, a)
+		}
+	})
+	return res
+}
+
+// resourceProducerCall returns the call of p that produces resource r.
+func resourceProducerCall(p *Prog, r *ResultArg) *Call {
+	for _, c := range p.Calls {
+		found := false
+		ForeachArg(c, func(arg Arg, _ *ArgCtx) {
+			found = found || arg == Arg(r)
+		})
+		if found {
+			return c
+		}
+	}
+	return nil
+}
+
+// callPosition returns the index of c in p, or -1 if c is nil or not in p.
+func callPosition(p *Prog, c *Call) int {
+	for i, c1 := range p.Calls {
+		if c1 == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// freshResourceCtor returns a call with default arguments to the first precise ctor of typ
+// that returns the resource, or nil if there is no such ctor.
+func freshResourceCtor(target *Target, typ *ResourceType) *Call {
+	for _, ctor := range target.resourceCtors[typ.Desc.Name] {
+		meta := ctor.Call
+		if !ctor.Precise || meta.Attrs.Disabled || meta.Attrs.NoGenerate {
+			continue
+		}
+		ret, ok := meta.Ret.(*ResourceType)
+		if !ok || !isCompatibleResourceImpl(typ.Desc.Kind, ret.Desc.Kind, true) {
+			continue
+		}
+		args := make([]Arg, len(meta.Args))
+		for i := range meta.Args {
+			field := &meta.Args[i]
+			args[i] = field.DefaultArg(field.Dir(DirIn))
+		}
+		c := MakeCall(meta, args)
+		target.assignSizesCall(c)
+		return c
+	}
+	return nil
+}
 
+// removeCallSet returns a copy of p0 without the calls with indices ids and the updated
+// index of the preserved call. Batch removals construct ids programmatically, so if ids
+// includes the preserved call itself, it's a bug in the set construction and is reported as an error.
+func removeCallSet(p0 *Prog, callIndex0 int, ids []int) (*Prog, int, error) {
+	remove := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if id < 0 || id >= len(p0.Calls) {
+			return nil, 0, fmt.Errorf("call removal set %v is out of range [0, %v)", ids, len(p0.Calls))
+		}
+		if id == callIndex0 {
+			return nil, 0, fmt.Errorf("call removal set %v includes the preserved call %v (%v)",
+				ids, callIndex0, p0.Calls[callIndex0].Meta.Name)
+		}
+		remove[id] = true
+	}
+	p, callIndex := p0.Clone(), callIndex0
+	for i := len(p0.Calls) - 1; i >= 0; i-- { // from back to front
+		if !remove[i] {
+			continue
+		}
+		p.RemoveCall(i)
+		if i < callIndex {
+			callIndex--
+		}
+	}
+	return p, callIndex, nil
+}
+
+// removeCallsOneByOne tries to remove calls one-by-one starting from the last one.
+// With opts.DisableCalls each call is first disabled and then removed, and whichever
+// of the two the predicate accepts last is committed.
+func removeCallsOneByOne(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool, opts *MinimizeOptions) (
+	*Prog, int, error) {
+	limit := opts.MaxRemovalsPerPhase
+	removed := 0
 	for i := len(p0.Calls) - 1; i >= 0; i-- {
-		if i == callIndex0 {
+		if limit > 0 && removed >= limit || opts.maxCallsReached(p0) {
+			break
+		}
+		if i == callIndex0 || opts.keepCall(p0.Calls[i]) {
 			continue
 		}
+		if opts.DisableCalls {
+			p := p0.Clone()
+			if disableCall(p, i) && pred(p, callIndex0, 4) {
+				p0 = p
+			}
+		}
 		callIndex := callIndex0
 		if i < callIndex {
 			callIndex--
@@ -144,12 +885,81 @@ func removeCalls(p0 *Prog, callIndex0 int, crash bool, pred func(*Prog, int, int
 		p := p0.Clone()
 		p.RemoveCall(i)
 		if !pred(p, callIndex, 1) {
+			if opts.learning() {
+				if learnInfluence(p.Target, p0, p, i) {
+					opts.stats.InfluenceUpdates++
+				}
+			}
 			continue
 		}
 		p0 = p
 		callIndex0 = callIndex
+		removed++
 	}
-	return p0, callIndex0
+	return p0, callIndex0, nil
+}
+
+// disableCall replaces call idx with a call to a syscall without arguments that returns the same
+// resource (e.g. a fd), and repoints users of the resource returned by the original call to it.
+// A call without arguments can't act on anything the program set up, so it's the closest to a no-op
+// that still keeps dependent calls connected to a resource, unlike RemoveCall.
+// Returns false if the call returns no used resource (removal is the better reduction then),
+// produces used resources in its arguments, or there is no such syscall.
+func disableCall(p *Prog, idx int) bool {
+	c := p.Calls[idx]
+	if c.Ret == nil || len(c.Ret.uses) == 0 {
+		return false
+	}
+	for _, arg := range c.Args {
+		if producesUsedResource(arg) {
+			return false
+		}
+	}
+	noop := noopResourceCtor(p.Target, c.Ret.Type().(*ResourceType), c.Meta)
+	if noop == nil {
+		return false
+	}
+	for _, arg := range c.Args {
+		removeArg(arg)
+	}
+	noop.Ret.uses = c.Ret.uses
+	for use := range noop.Ret.uses {
+		use.Res = noop.Ret
+	}
+	c.Ret.uses = nil
+	p.Calls[idx] = noop
+	return true
+}
+
+// noopResourceCtor returns a call to a precise ctor of typ without arguments other than skip,
+// or nil if there is no such ctor.
+func noopResourceCtor(target *Target, typ *ResourceType, skip *Syscall) *Call {
+	for _, ctor := range target.resourceCtors[typ.Desc.Name] {
+		meta := ctor.Call
+		if !ctor.Precise || meta == skip || len(meta.Args) != 0 || meta.Attrs.Disabled || meta.Attrs.NoGenerate {
+			continue
+		}
+		ret, ok := meta.Ret.(*ResourceType)
+		if !ok || !isCompatibleResourceImpl(typ.Desc.Kind, ret.Desc.Kind, true) {
+			continue
+		}
+		c := MakeCall(meta, nil)
+		if err := target.Neutralize(c, true); err != nil {
+			continue
+		}
+		return c
+	}
+	return nil
+}
+
+func producesUsedResource(arg Arg) bool {
+	used := false
+	ForeachSubArg(arg, func(arg Arg, _ *ArgCtx) {
+		if a, ok := arg.(*ResultArg); ok && len(a.uses) != 0 {
+			used = true
+		}
+	})
+	return used
 }
 
 func resetCallProps(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool) *Prog {
@@ -163,7 +973,7 @@ func resetCallProps(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool) *
 			anyDifferent = true
 		}
 	}
-	if anyDifferent && pred(p, callIndex0, 1) {
+	if anyDifferent && pred(p, callIndex0, 3) {
 		return p
 	}
 	return p0
@@ -176,7 +986,7 @@ func minimizeCallProps(p0 *Prog, callIndex, callIndex0 int, pred func(*Prog, int
 	if props.FailNth > 0 {
 		p := p0.Clone()
 		p.Calls[callIndex].Props.FailNth = 0
-		if pred(p, callIndex0, 1) {
+		if pred(p, callIndex0, 3) {
 			p0 = p
 		}
 	}
@@ -185,7 +995,7 @@ func minimizeCallProps(p0 *Prog, callIndex, callIndex0 int, pred func(*Prog, int
 	if props.Async {
 		p := p0.Clone()
 		p.Calls[callIndex].Props.Async = false
-		if pred(p, callIndex0, 1) {
+		if pred(p, callIndex0, 3) {
 			p0 = p
 		}
 	}
@@ -194,7 +1004,7 @@ func minimizeCallProps(p0 *Prog, callIndex, callIndex0 int, pred func(*Prog, int
 	if props.Rerun > 0 {
 		p := p0.Clone()
 		p.Calls[callIndex].Props.Rerun = 0
-		if pred(p, callIndex0, 1) {
+		if pred(p, callIndex0, 3) {
 			p0 = p
 		}
 	}
@@ -211,6 +1021,9 @@ type minimizeArgsCtx struct {
 	crash      bool
 	pred       func(*Prog, int, int) bool
 	triedPaths map[string]bool
+	// compValues are values of integer arguments that are deferred, see MinimizeOptions.CompValues.
+	compValues map[uint64]bool
+	deferred   bool
 }
 
 func (ctx *minimizeArgsCtx) do(arg Arg, field, path string) bool {
@@ -219,9 +1032,18 @@ func (ctx *minimizeArgsCtx) do(arg Arg, field, path string) bool {
 		return false
 	}
 	// p0 := *ctx.p0
-	if arg.Type().minimize(ctx, arg, path) {
+	deferred := ctx.deferred
+	ctx.deferred = false
+	res := arg.Type().minimize(ctx, arg, path)
+	argDeferred := ctx.deferred
+	ctx.deferred = deferred || argDeferred
+	if res {
 		return true
 	}
+	if argDeferred {
+		// Paths with deferred arguments are not marked as tried, so that they are revisited.
+		return false
+	}
 	// if *ctx.p0 == ctx.p {
 	// 	// If minimize committed a new program, it must return true.
 	// 	// Otherwise *ctx.p0 and ctx.p will point to the same program
@@ -272,9 +1094,112 @@ func (typ *PtrType) minimize(ctx *minimizeArgsCtx, arg Arg, path string) bool {
 		ctx.triedPaths[path1] = true
 		return true
 	}
+	if ctx.collapsePointer(a, path) {
+		return true
+	}
 	return ctx.do(a.Res, "", path)
 }
 
+// collapsePointer tries to drop one level of indirection below pointer a: if the pointee holds
+// a single non-NULL pointer to a value of the same type (e.g. a linked list of recursive structs),
+// a is made to point to that value directly. The tried path includes the pointer depth below a,
+// so a committed collapse is retried on the shorter chain, and restarts terminate as the depth
+// decreases with every commit.
+func (ctx *minimizeArgsCtx) collapsePointer(a *PointerArg, path string) bool {
+	inner := singleInnerPointer(a.Res)
+	if inner == nil || inner.Res.Type() != a.Res.Type() || inner.Res.Dir() != a.Res.Dir() {
+		return false
+	}
+	path1 := fmt.Sprintf("%v<%v", path, pointerDepth(a.Res))
+	if ctx.triedPaths[path1] {
+		return false
+	}
+	ctx.triedPaths[path1] = true
+	addr, res := inner.Address, inner.Res
+	replaceArg(inner, MakeSpecialPointerArg(inner.Type(), inner.Dir(), 0))
+	removeArg(a.Res)
+	a.Address, a.Res = addr, res
+	ctx.target.assignSizesCall(ctx.call)
+	if ctx.pred(ctx.p, ctx.callIndex0, 2) {
+		*ctx.p0 = ctx.p
+	}
+	return true
+}
+
+// singleInnerPointer returns the only non-NULL pointer within arg (not looking behind pointers),
+// or nil if there are none or several such pointers.
+func singleInnerPointer(arg Arg) *PointerArg {
+	var inner *PointerArg
+	count := 0
+	ForeachSubArg(arg, func(arg1 Arg, ctx *ArgCtx) {
+		if ptr, ok := arg1.(*PointerArg); ok {
+			ctx.Stop = true
+			if ptr.Res != nil {
+				inner = ptr
+				count++
+			}
+		}
+	})
+	if count != 1 {
+		return nil
+	}
+	return inner
+}
+
+// pointerDepth returns the maximum number of pointers on a path from arg to its subargs.
+func pointerDepth(arg Arg) int {
+	depth := 0
+	ForeachSubArg(arg, func(arg1 Arg, ctx *ArgCtx) {
+		if ptr, ok := arg1.(*PointerArg); ok {
+			ctx.Stop = true
+			if ptr.Res != nil {
+				if d := 1 + pointerDepth(ptr.Res); d > depth {
+					depth = d
+				}
+			}
+		}
+	})
+	return depth
+}
+
+// minimize shrinks the mapped region towards the minimal page count allowed by the type.
+// Length fields referring to the region are updated by assignSizesCall.
+func (typ *VmaType) minimize(ctx *minimizeArgsCtx, arg Arg, path string) bool {
+	a := arg.(*PointerArg)
+	if a.IsSpecial() || a.VmaSize == 0 {
+		return false
+	}
+	pageSize := ctx.target.PageSize
+	pages0 := a.VmaSize / pageSize
+	minPages := typ.RangeBegin
+	if minPages == 0 {
+		minPages = 1
+	}
+	pages := pages0
+	for step := pages - minPages; pages > minPages && step > 0; {
+		if pages-step >= minPages {
+			a.VmaSize = (pages - step) * pageSize
+			ctx.target.assignSizesCall(ctx.call)
+			if ctx.pred(ctx.p, ctx.callIndex0, 2) {
+				pages -= step
+				continue
+			}
+			a.VmaSize = pages * pageSize
+			ctx.target.assignSizesCall(ctx.call)
+		}
+		step /= 2
+		if ctx.crash {
+			break
+		}
+	}
+	if pages != pages0 {
+		*ctx.p0 = ctx.p
+		ctx.triedPaths[path] = true
+		return true
+	}
+	return false
+}
+
 func (typ *ArrayType) minimize(ctx *minimizeArgsCtx, arg Arg, path string) bool {
 	a := arg.(*GroupArg)
 
@@ -292,6 +1217,32 @@ func (typ *ArrayType) minimize(ctx *minimizeArgsCtx, arg Arg, path string) bool
 		return true
 	}
 
+	// Try to remove the back half of the elements, then the back quarter and so on,
+	// which is faster than one-by-one removal for long arrays. Each chunk size is tried once,
+	// the rest is left to one-by-one removal below.
+	minLen := 0
+	if typ.Kind == ArrayRangeLen {
+		minLen = int(typ.RangeBegin)
+	}
+	if !ctx.crash && (typ.Kind == ArrayRandLen || typ.Kind == ArrayRangeLen) {
+		for step := (len(a.Inner) - minLen) / 2; step >= 2; step /= 2 {
+			stepPath := fmt.Sprintf("%v-tail%v", path, step)
+			if ctx.triedPaths[stepPath] {
+				continue
+			}
+			ctx.triedPaths[stepPath] = true
+			for _, elem := range a.Inner[len(a.Inner)-step:] {
+				removeArg(elem)
+			}
+			a.Inner = a.Inner[:len(a.Inner)-step]
+			ctx.target.assignSizesCall(ctx.call)
+			if ctx.pred(ctx.p, ctx.callIndex0, 2) {
+				*ctx.p0 = ctx.p
+			}
+			return true
+		}
+	}
+
 	for i := len(a.Inner) - 1; i >= 0; i-- {
 		elem := a.Inner[i]
 		elemPath := fmt.Sprintf("%v-%v", path, i)
@@ -347,6 +1298,10 @@ func minimizeInt(ctx *minimizeArgsCtx, arg Arg, path string) bool {
 	if a.Val == def.Val {
 		return false
 	}
+	if ctx.compValues[a.Val] {
+		ctx.deferred = true
+		return false
+	}
 	v0 := a.Val
 	a.Val = def.Val
 
@@ -379,14 +1334,58 @@ func (typ *ResourceType) minimize(ctx *minimizeArgsCtx, arg Arg, path string) bo
 	a.Res, a.Val = nil, typ.Default()
 	if ctx.pred(ctx.p, ctx.callIndex0, 2) {
 		*ctx.p0 = ctx.p
-	} else {
-		a.Res, a.Val = r0, 0
-		a.Res.uses[a] = true
+		ctx.triedPaths[path] = true
+		return true
 	}
+	a.Val = 0
+	// If the arg is the only user of the resource, repointing it to another producer
+	// lets call removal drop the original producer (and the calls it depends on) later.
+	if len(r0.uses) == 0 {
+		for _, r := range resourceProducers(ctx.p, ctx.call, typ) {
+			if r == r0 {
+				continue
+			}
+			a.Res = r
+			if r.uses == nil {
+				r.uses = make(map[*ResultArg]bool)
+			}
+			r.uses[a] = true
+			if ctx.pred(ctx.p, ctx.callIndex0, 2) {
+				*ctx.p0 = ctx.p
+				ctx.triedPaths[path] = true
+				return true
+			}
+			delete(r.uses, a)
+		}
+	}
+	a.Res = r0
+	a.Res.uses[a] = true
 	ctx.triedPaths[path] = true
 	return true
 }
 
+// resourceProducers returns resources produced by calls of p preceding call c
+// that can be passed as resource typ, in the order of calls.
+func resourceProducers(p *Prog, c *Call, typ *ResourceType) []*ResultArg {
+	var res []*ResultArg
+	for _, c1 := range p.Calls {
+		if c1 == c {
+			break
+		}
+		ForeachArg(c1, func(arg Arg, _ *ArgCtx) {
+			a, ok := arg.(*ResultArg)
+			if !ok || a.Dir() == DirIn {
+				return
+			}
+			if typ1, ok := a.Type().(*ResourceType); ok &&
+				isCompatibleResourceImpl(typ.Desc.Kind, typ1.Desc.Kind, true) {
+				res = append(res, a)
+			}
+		})
+	}
+	return res
+}
+
 func (typ *BufferType) minimize(ctx *minimizeArgsCtx, arg Arg, path string) bool {
 	if arg.Dir() == DirOut {
 		return false
@@ -423,14 +1422,19 @@ func (typ *BufferType) minimize(ctx *minimizeArgsCtx, arg Arg, path string) bool
 	case BufferFilename:
 		// Try to undo target.SpecialFileLenghts mutation
 		// and reduce file name length.
-		if !typ.Varlen() {
-			return false
-		}
 		data0 := append([]byte{}, a.Data()...)
 		a.data = bytes.TrimRight(a.Data(), specialFileLenPad+"\x00")
 		if !typ.NoZ {
 			a.data = append(a.data, 0)
 		}
+		if !typ.Varlen() {
+			// Fixed-length buffers can't be shrunk,
+			// but the padding can still be zeroed in place.
+			if len(a.data) > len(data0) {
+				a.data = a.data[:len(data0)]
+			}
+			a.data = append(a.data, make([]byte, len(data0)-len(a.data))...)
+		}
 		if bytes.Equal(a.data, data0) {
 			return false
 		}
@@ -478,25 +1482,54 @@ func (q *IntQueue) IsEmpty() bool {
 // Unrelated calls are the calls that don't use any resources/files from
 // the transitive closure of the resources/files used by the target call.
 // This may significantly reduce large generated programs in a single step.
-func removeUnrelatedCalls(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool) (*Prog, int) {
+// If opts.MaxRemovalsPerPhase is not 0, at most that many calls are removed starting from the last one.
+func removeUnrelatedCalls(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool, opts *MinimizeOptions) (
+	*Prog, int, error) {
 	keepCalls := relatedCalls(p0, callIndex0)
-	if len(p0.Calls)-len(keepCalls) < 3 {
-		return p0, callIndex0
-	}
-	p, callIndex := p0.Clone(), callIndex0
+	var ids []int
 	for i := len(p0.Calls) - 1; i >= 0; i-- {
-		if keepCalls[i] {
-			continue
-		}
-		p.RemoveCall(i)
-		if i < callIndex {
-			callIndex--
+		if !keepCalls[i] && !opts.keepCall(p0.Calls[i]) {
+			ids = append(ids, i)
 		}
 	}
+	if len(ids) < 3 {
+		return p0, callIndex0, nil
+	}
+	if limit := opts.MaxRemovalsPerPhase; limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	p, callIndex, err := removeCallSet(p0, callIndex0, ids)
+	if err != nil {
+		return p0, callIndex0, err
+	}
 	if !pred(p, callIndex, 1) {
-		return p0, callIndex0
+		return p0, callIndex0, nil
+	}
+	return p, callIndex, nil
+}
+
+// ResourceClosureSize returns the number of calls in the transitive closure of resources
+// and files used by call callIndex (including the call itself). It characterizes how
+// entangled the program is and doesn't depend on the influence matrix.
+func ResourceClosureSize(p *Prog, callIndex int) int {
+	return len(RelatedCalls(p, callIndex))
+}
+
+// RelatedCalls returns indices of calls that transitively share resources or files
+// with call callIndex (including the call itself), or nil if callIndex is out of range.
+// These are the calls that minimization keeps when removing unrelated calls.
+func RelatedCalls(p *Prog, callIndex int) map[int]bool {
+	if callIndex < 0 || callIndex >= len(p.Calls) {
+		return nil
 	}
-	return p, callIndex
+	return relatedCalls(p, callIndex)
+}
+
+// CallUses returns the resources and files used by call c. Keys are *ResultArg
+// for resources (the call's own resource args, their producers and consumers)
+// and strings for file names. Two calls are related if their uses intersect.
+func CallUses(c *Call) map[any]bool {
+	return uses(c)
 }
 
 func relatedCalls(p0 *Prog, callIndex0 int) map[int]bool {
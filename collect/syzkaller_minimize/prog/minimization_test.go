@@ -4,7 +4,10 @@
 package prog
 
 import (
+	"fmt"
 	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -251,7 +254,10 @@ func TestMinimize(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to deserialize original program #%v: %v", ti, err)
 		}
-		p1, ci := Minimize(p, test.callIndex, false, test.pred)
+		p1, ci, err := Minimize(p, test.callIndex, false, test.pred)
+		if err != nil {
+			t.Fatalf("minimization failed #%v: %v", ti, err)
+		}
 		res := p1.Serialize()
 		if string(res) != test.result {
 			t.Fatalf("minimization produced wrong result #%v\norig:\n%v\nexpect:\n%v\ngot:\n%v\n",
@@ -273,13 +279,16 @@ func TestMinimizeRandom(t *testing.T) {
 		for _, crash := range []bool{false, true} {
 			p := target.Generate(rs, 5, ct)
 			copyP := p.Clone()
-			minP, _ := Minimize(p, len(p.Calls)-1, crash, func(p1 *Prog, callIndex int, _ int) bool {
+			minP, _, err := Minimize(p, len(p.Calls)-1, crash, func(p1 *Prog, callIndex int, _ int) bool {
 				if r.Intn(2) == 0 {
 					return false
 				}
 				copyP = p1.Clone()
 				return true
 			})
+			if err != nil {
+				t.Fatal(err)
+			}
 			got := string(minP.Serialize())
 			want := string(copyP.Serialize())
 			if got != want {
@@ -296,11 +305,1332 @@ func TestMinimizeCallIndex(t *testing.T) {
 	for i := 0; i < iters; i++ {
 		p := target.Generate(rs, 5, ct)
 		ci := r.Intn(len(p.Calls))
-		p1, ci1 := Minimize(p, ci, r.Intn(2) == 0, func(p1 *Prog, callIndex int, _ int) bool {
+		p1, ci1, err := Minimize(p, ci, r.Intn(2) == 0, func(p1 *Prog, callIndex int, _ int) bool {
 			return r.Intn(2) == 0
 		})
+		if err != nil {
+			t.Fatal(err)
+		}
 		if ci1 < 0 || ci1 >= len(p1.Calls) || p.Calls[ci].Meta.Name != p1.Calls[ci1].Meta.Name {
 			t.Fatalf("bad call index after minimization")
 		}
 	}
 }
+
+func TestMinimizeMaxRemovalsPerPhase(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const limit = 2
+	p, err := target.Deserialize([]byte(strings.Repeat("sched_yield()\n", 5)+
+		"pipe2(&(0x7f0000000000), 0x0)\n"+
+		strings.Repeat("sched_yield()\n", 7)), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace []int
+	var phases []callRemovalPhase
+	for i, phase := range callRemovalPhases {
+		i, phase := i, phase
+		phases = append(phases, func(p0 *Prog, callIndex0 int, pred func(*Prog, int, int) bool,
+			opts *MinimizeOptions) (*Prog, int, error) {
+			ncalls := len(p0.Calls)
+			p1, ci, err := phase(p0, callIndex0, pred, opts)
+			if err != nil {
+				return p1, ci, err
+			}
+			if removed := ncalls - len(p1.Calls); removed > limit {
+				t.Errorf("phase %v removed %v calls, limit is %v", i, removed, limit)
+			}
+			trace = append(trace, i)
+			return p1, ci, nil
+		})
+	}
+	opts := &MinimizeOptions{MaxRemovalsPerPhase: limit}
+	p1, ci, err := runCallRemovalPhases(p, 5, func(*Prog, int, int) bool { return true }, opts, phases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Calls) != 1 || ci != 0 || p1.Calls[0].Meta.Name != "pipe2" {
+		t.Fatalf("bad minimization result: call index %v\n%s", ci, p1.Serialize())
+	}
+	if len(trace) < 2*len(phases) {
+		t.Fatalf("phases were not cycled: %v", trace)
+	}
+	for i, phase := range trace {
+		if phase != i%len(phases) {
+			t.Fatalf("phases run out of order: %v", trace)
+		}
+	}
+}
+
+func TestRemoveCallSetPreservedCall(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"+
+		"sched_yield()\n"+
+		"sched_yield()\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, ci, err := removeCallSet(p, 1, []int{0, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Calls) != 2 || ci != 0 || p1.Calls[ci].Meta.Name != "pipe2" {
+		t.Fatalf("bad removal result: call index %v\n%s", ci, p1.Serialize())
+	}
+	if _, _, err := removeCallSet(p, 1, []int{0, 1, 3}); err == nil {
+		t.Fatalf("removal of the preserved call was not reported")
+	}
+	if _, _, err := removeCallSet(p, 1, []int{4}); err == nil {
+		t.Fatalf("out of range removal was not reported")
+	}
+	// Without a preserved call, -1 is still an out of range id rather than the preserved call.
+	p1, ci, err = removeCallSet(p, -1, []int{0, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Calls) != 2 || ci != -1 {
+		t.Fatalf("bad removal result: call index %v\n%s", ci, p1.Serialize())
+	}
+	if _, _, err := removeCallSet(p, -1, []int{-1}); err == nil {
+		t.Fatalf("out of range removal was not reported")
+	}
+	if len(p.Calls) != 4 {
+		t.Fatalf("original program was modified:\n%s", p.Serialize())
+	}
+}
+
+func TestMinimizeFixedLenFilename(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	p, err := target.Deserialize([]byte("test$length29(&(0x7f0000000000)={'./file0aaa', "+
+		"'./file1aaaaaaaaaaaaa', 0xa, 0x14, 0x21})\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, _, err := Minimize(p, 0, false, func(p *Prog, _, _ int) bool {
+		// Keep the pointer to the struct, it's what we want to minimize.
+		return p.Calls[0].Args[0].(*PointerArg).Res != nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "test$length29(&(0x7f0000000000)={'./file0\\x00', './file1\\x00', 0xa, 0x14, 0x21})\n"
+	if got := string(p1.Serialize()); got != want {
+		t.Fatalf("got:\n%v\nwant:\n%v", got, want)
+	}
+	fields := p1.Calls[0].Args[0].(*PointerArg).Res.(*GroupArg).Inner
+	for i, size := range []int{10, 20} {
+		if data := fields[i].(*DataArg).Data(); len(data) != size {
+			t.Errorf("field %v: filename length changed to %v, want %v", i, len(data), size)
+		}
+	}
+}
+
+func TestMinimizeDisableCalls(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	p, err := target.Deserialize([]byte("r0 = mutate5(&(0x7f0000000000)='./file0\\x00', 0x0)\n"+
+		"mutate6(r0, &(0x7f0000000000)=\"00\", 0x1)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mutate5 can't be removed, since mutate6 needs the fd, but it can be replaced
+	// with a call without arguments that returns a fd.
+	pred := func(p1 *Prog, callIndex, _ int) bool {
+		return len(p1.Calls) == 2 && callIndex == 1 && p1.Calls[1].Args[0].(*ResultArg).Res != nil
+	}
+	for _, disable := range []bool{false, true} {
+		kinds := make(map[string]int)
+		opts := MinimizeOptions{
+			DisableCalls: disable,
+			NoInfluence:  true,
+			ArgsFocus:    true,
+			OnReduce: func(p *Prog, callIndex int, kind string) {
+				kinds[kind]++
+			},
+		}
+		p1, ci, stats, err := MinimizeWithStats(p, 1, false, pred, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := p1.Calls[0]
+		disabled := c.Meta.Name != "mutate5"
+		if disabled != disable || ci != 1 || (stats.Disables != 0) != disable ||
+			(kinds[ReduceDisable] != 0) != disable || kinds[ReduceRemoveCall] != 0 {
+			t.Fatalf("disable=%v: got %v disables, reductions %v, program:\n%s",
+				disable, stats.Disables, kinds, p1.Serialize())
+		}
+		if disabled {
+			ret, ok := c.Meta.Ret.(*ResourceType)
+			if len(c.Args) != 0 || !ok || ret.Desc.Name != "fd" ||
+				p1.Calls[1].Args[0].(*ResultArg).Res != c.Ret {
+				t.Fatalf("bad disabled call:\n%s", p1.Serialize())
+			}
+		}
+	}
+}
+
+func TestResourceClosureSize(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("pipe2(&(0x7f0000000000)={<r0=>0xffffffffffffffff, <r1=>0xffffffffffffffff}, 0x0)\n"+
+		"sched_yield()\n"+
+		"dup(r1)\n"+
+		"close(r0)\n"+
+		"mkdir(&(0x7f0000000000)='./file0\\x00', 0x0)\n"+
+		"rmdir(&(0x7f0000000000)='./file0\\x00')\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for callIndex, want := range []int{3, 1, 3, 3, 2, 2} {
+		if got := ResourceClosureSize(p, callIndex); got != want {
+			t.Errorf("call %v: got closure size %v, want %v", callIndex, got, want)
+		}
+	}
+	if got := ResourceClosureSize(p, len(p.Calls)); got != 0 {
+		t.Errorf("got closure size %v for a bad call index", got)
+	}
+	if got, want := RelatedCalls(p, 2), map[int]bool{0: true, 2: true, 3: true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got related calls %v, want %v", got, want)
+	}
+	if got := RelatedCalls(p, -1); got != nil {
+		t.Errorf("got related calls %v for a bad call index", got)
+	}
+	if !CallUses(p.Calls[4])["./file0"] {
+		t.Errorf("mkdir does not use ./file0: %v", CallUses(p.Calls[4]))
+	}
+	if len(CallUses(p.Calls[1])) != 0 {
+		t.Errorf("sched_yield uses %v", CallUses(p.Calls[1]))
+	}
+}
+
+func TestMinimizeNoInfluence(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"+
+		"sched_yield()\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, ci, err := MinimizeWithOptions(p, 1, false, func(p *Prog, callIndex, _ int) bool {
+		return p.Calls[callIndex].Meta.Name == "pipe2"
+	}, MinimizeOptions{NoInfluence: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Calls) != 1 || ci != 0 {
+		t.Fatalf("bad minimization result: call index %v\n%s", ci, p1.Serialize())
+	}
+}
+
+func TestMinimizeWithoutInfluenceMatrix(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.InfluenceMatrix != nil {
+		t.Fatalf("influence matrix is analyzed without AnalyzeStaticInfluence")
+	}
+	p, err := target.Deserialize([]byte("mmap(&(0x7f0000000000/0x1000)=nil, 0x1000, 0x3, 0x32, 0xffffffffffffffff, 0x0)\n"+
+		"sched_yield()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, ci, err := Minimize(p, 2, false, func(p *Prog, callIndex, _ int) bool {
+		return p.Calls[callIndex].Meta.Name == "pipe2"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Calls) != 1 || ci != 0 {
+		t.Fatalf("bad minimization result: call index %v\n%s", ci, p1.Serialize())
+	}
+}
+
+func TestMinimizeBadCallIndex(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\npipe2(&(0x7f0000000000), 0x0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ci := range []int{-2, 2, 10} {
+		_, _, err := Minimize(p, ci, false, func(*Prog, int, int) bool {
+			t.Fatalf("predicate is called for a bad call index %v", ci)
+			return false
+		})
+		if err == nil {
+			t.Errorf("no error for a bad call index %v", ci)
+		}
+	}
+}
+
+func TestMinimizeSingleCall(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, ci, err := Minimize(p, 0, false, func(p *Prog, callIndex, minimizeType int) bool {
+		if minimizeType == 1 {
+			t.Fatalf("call removal is attempted for a single call program")
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Calls) != 1 || ci != 0 {
+		t.Fatalf("bad minimization result: call index %v\n%s", ci, p1.Serialize())
+	}
+}
+
+func TestCallRemovalPhaseBadCallIndex(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\npipe2(&(0x7f0000000000), 0x0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := func(p0 *Prog, callIndex0 int, _ func(*Prog, int, int) bool, _ *MinimizeOptions) (*Prog, int, error) {
+		p := p0.Clone()
+		p.RemoveCall(0)
+		return p, callIndex0 - 2, nil
+	}
+	p1, ci, err := runCallRemovalPhases(p, 1, func(*Prog, int, int) bool { return true },
+		&MinimizeOptions{}, []callRemovalPhase{broken})
+	if err == nil {
+		t.Fatalf("no error for a negative call index")
+	}
+	if p1 != p || ci != 1 {
+		t.Fatalf("the broken phase result is not discarded")
+	}
+}
+
+func TestMinimizeVma(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	p, err := target.Deserialize([]byte("test$vma0(&(0x7f0000000000/0x8000)=nil, 0x8000, "+
+		"&(0x7f0000008000/0x5000)=nil, 0x5000, &(0x7f0000010000/0x9000)=nil, 0x9000)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		// minPages is the number of pages of the first vma the predicate requires.
+		minPages uint64
+		crash    bool
+		result   string
+	}{
+		{
+			minPages: 0,
+			result: "test$vma0(&(0x7f0000000000/0x1000)=nil, 0x1000, " +
+				"&(0x7f0000008000/0x5000)=nil, 0x5000, &(0x7f0000010000/0x7000)=nil, 0x7000)\n",
+		},
+		{
+			minPages: 3,
+			result: "test$vma0(&(0x7f0000000000/0x3000)=nil, 0x3000, " +
+				"&(0x7f0000008000/0x5000)=nil, 0x5000, &(0x7f0000010000/0x7000)=nil, 0x7000)\n",
+		},
+		{
+			// With crash only a single halving step is tried per vma.
+			minPages: 3,
+			crash:    true,
+			result: "test$vma0(&(0x7f0000000000/0x8000)=nil, 0x8000, " +
+				"&(0x7f0000008000/0x5000)=nil, 0x5000, &(0x7f0000010000/0x7000)=nil, 0x7000)\n",
+		},
+	}
+	for i, test := range tests {
+		p1, _, err := Minimize(p, 0, test.crash, func(p *Prog, _, _ int) bool {
+			vma := p.Calls[0].Args[0].(*PointerArg)
+			length := p.Calls[0].Args[1].(*ConstArg)
+			if vma.VmaSize != length.Val {
+				t.Fatalf("length %v does not match vma size %v", length.Val, vma.VmaSize)
+			}
+			return vma.VmaSize >= test.minPages*target.PageSize
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(p1.Serialize()); got != test.result {
+			t.Errorf("#%v: got:\n%v\nwant:\n%v", i, got, test.result)
+		}
+	}
+}
+
+func TestMinimizeKeepCalls(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"getpid()\n"+
+		"sched_yield()\n"+
+		"getuid()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"+
+		"sched_yield()\n"+
+		"getpid()\n"+
+		"getuid()\n"+
+		"getgid()\n"+
+		"getpid()\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, noInfluence := range []bool{false, true} {
+		opts := MinimizeOptions{
+			NoInfluence: noInfluence,
+			KeepCalls:   map[string]bool{"getpid": true},
+		}
+		p1, ci, err := MinimizeWithOptions(p, 4, false, func(p *Prog, callIndex, _ int) bool {
+			return p.Calls[callIndex].Meta.Name == "pipe2"
+		}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "getpid()\n" +
+			"pipe2(0x0, 0x0)\n" +
+			"getpid()\n" +
+			"getpid()\n"
+		if got := string(p1.Serialize()); got != want || ci != 1 {
+			t.Errorf("noinfluence=%v: got call index %v:\n%v\nwant:\n%v", noInfluence, ci, got, want)
+		}
+	}
+}
+
+func TestMinimizeArgsOnly(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"pipe2(&(0x7f0000000000), 0x80000)\n"+
+		"getpid()\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1, ci, err := MinimizeWithOptions(p, 1, false, func(p *Prog, callIndex, minimizeType int) bool {
+		if minimizeType == 1 {
+			t.Fatalf("call removal is attempted with ArgsOnly")
+		}
+		return p.Calls[callIndex].Meta.Name == "pipe2"
+	}, MinimizeOptions{ArgsOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "sched_yield()\n" +
+		"pipe2(0x0, 0x0)\n" +
+		"getpid()\n"
+	if got := string(p1.Serialize()); got != want || ci != 1 {
+		t.Fatalf("got call index %v:\n%v\nwant:\n%v", ci, got, want)
+	}
+}
+
+func TestMinimizeMaxCalls(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"getpid()\n"+
+		"getuid()\n"+
+		"sched_yield()\n"+
+		"getpid()\n"+
+		"pipe2(&(0x7f0000000000), 0x80000)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		maxCalls int
+		calls    int
+		argExecs bool
+	}{
+		{0, 2, true},
+		{3, 3, false},
+		{6, 6, false},
+	}
+	for i, test := range tests {
+		argExecs := 0
+		// Bulk removals that leave a single call are rejected, so calls are removed one-by-one.
+		pred := func(p *Prog, callIndex, minimizeType int) bool {
+			if minimizeType == 2 {
+				argExecs++
+			}
+			return len(p.Calls) >= 2
+		}
+		p1, ci, err := MinimizeWithOptions(p, 5, false, pred, MinimizeOptions{MaxCalls: test.maxCalls})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p1.Calls) != test.calls || p1.Calls[ci].Meta.Name != "pipe2" {
+			t.Errorf("#%v: got %v calls, call index %v, want %v calls\n%s",
+				i, len(p1.Calls), ci, test.calls, p1.Serialize())
+		}
+		if (argExecs != 0) != test.argExecs {
+			t.Errorf("#%v: got %v argument minimization executions", i, argExecs)
+		}
+	}
+}
+
+func TestMinimizeOnReduce(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"getpid()\n"+
+		"pipe2(&(0x7f0000000000), 0x80000)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepted, reduced []string
+	pred := func(p *Prog, callIndex, minimizeType int) bool {
+		// Keep getpid to get both accepted and rejected call removals.
+		ok := hasCall(p, "getpid", 0, len(p.Calls))
+		if ok {
+			accepted = append(accepted, string(p.Serialize()))
+		}
+		return ok
+	}
+	kinds := make(map[string]int)
+	p1, ci, err := MinimizeWithOptions(p, 2, false, pred, MinimizeOptions{
+		OnReduce: func(p *Prog, callIndex int, kind string) {
+			if p.Calls[callIndex].Meta.Name != "pipe2" {
+				t.Errorf("bad call index %v in OnReduce", callIndex)
+			}
+			kinds[kind]++
+			reduced = append(reduced, string(p.Serialize()))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reduced, accepted) {
+		t.Fatalf("OnReduce is invoked for:\n%q\nwant accepted candidates:\n%q", reduced, accepted)
+	}
+	if kinds[ReduceRemoveCall] == 0 || kinds[ReduceArg] == 0 {
+		t.Fatalf("missing reductions: %v", kinds)
+	}
+	if last := reduced[len(reduced)-1]; last != string(p1.Serialize()) || p1.Calls[ci].Meta.Name != "pipe2" {
+		t.Fatalf("the last reduction %q doesn't match the result:\n%s", last, p1.Serialize())
+	}
+}
+
+func TestMinimizeCallProps(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("getpid() (async)\n"+
+		"pipe2(0x0, 0x0) (async, rerun: 100)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, needAsync := range []bool{false, true} {
+		tried := false
+		pred := func(p *Prog, callIndex, minimizeType int) bool {
+			if len(p.Calls) != 2 {
+				return false
+			}
+			if minimizeType == 3 && !p.Calls[1].Props.Async {
+				tried = true
+			}
+			// The bug needs async execution of pipe2.
+			return !needAsync || p.Calls[1].Props.Async
+		}
+		p1, ci, err := MinimizeWithOptions(p, 1, false, pred, MinimizeOptions{
+			OnReduce: func(p *Prog, callIndex int, kind string) {
+				if needAsync && !p.Calls[callIndex].Props.Async {
+					t.Errorf("dropping of the required async is committed:\n%s", p.Serialize())
+				}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "getpid()\npipe2(0x0, 0x0)\n"
+		if needAsync {
+			want = "getpid()\npipe2(0x0, 0x0) (async)\n"
+		}
+		if got := string(p1.Serialize()); got != want || ci != 1 || !tried {
+			t.Errorf("needAsync=%v: got call index %v, async drop tried %v:\n%s\nwant:\n%s",
+				needAsync, ci, tried, got, want)
+		}
+	}
+}
+
+func TestMinimizeArrayChunks(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	var elems []string
+	for i := 0; i < 32; i++ {
+		elems = append(elems, fmt.Sprintf("\"%02x\"", i+1))
+	}
+	p, err := target.Deserialize([]byte(fmt.Sprintf("test$length25(&(0x7f0000000000)=[%v], 0x20)\n",
+		strings.Join(elems, ", "))), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execs := 0
+	pred := func(p *Prog, callIndex, minimizeType int) bool {
+		execs++
+		// The first 3 elements are needed.
+		arr, ok := p.Calls[0].Args[0].(*PointerArg).Res.(*GroupArg)
+		if !ok || len(arr.Inner) < 3 {
+			return false
+		}
+		for i := 0; i < 3; i++ {
+			if data := arr.Inner[i].(*DataArg).Data(); len(data) != 1 || data[0] != byte(i+1) {
+				return false
+			}
+		}
+		return true
+	}
+	p1, _, err := Minimize(p, 0, false, pred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "test$length25(&(0x7f0000000000)=[\"01\", \"02\", \"03\"], 0x3)\n"
+	if got := string(p1.Serialize()); got != want {
+		t.Fatalf("got:\n%v\nwant:\n%v", got, want)
+	}
+	// One-by-one removal would need at least 29 executions for the elements alone.
+	if execs >= 29 {
+		t.Fatalf("minimization took %v executions", execs)
+	}
+}
+
+func TestMinimizeOnRetain(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("r0 = test$produce_inout_res()\n"+
+		"test$update_inout_res(&(0x7f0000000000)=r0)\n"+
+		"test$res0()\n"+
+		"test$consume_inout_res(r0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.AnalyzeStaticInfluence()
+	defer func() {
+		target.InfluenceMatrix = nil
+	}()
+	paths := make(map[int][]int)
+	opts := MinimizeOptions{
+		OnRetain: func(p1 *Prog, path []int) {
+			if p1 != p {
+				t.Errorf("OnRetain got a different program")
+			}
+			paths[path[0]] = path
+		},
+	}
+	if got := frontRemovalCandidates(p, 3, &opts); !reflect.DeepEqual(got, []int{2}) {
+		t.Fatalf("got front removal candidates %v, want [2]", got)
+	}
+	// produce_inout_res influences consume_inout_res directly as well, but it's added
+	// to the retained calls while following the influence of update_inout_res.
+	want := map[int][]int{
+		0: {0, 1, 3},
+		1: {1, 3},
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got retention paths %v, want %v", paths, want)
+	}
+}
+
+func TestMinimizeCollapsePointer(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	// A linked list of 4 nested structs, the intermediate ones can be dropped.
+	p, err := target.Deserialize([]byte("test$recur2(&(0x7f0000000000)={0x0, 0x0, 0x0, 0x0, "+
+		"&(0x7f0000000100)={0x0, 0x0, 0x0, 0x0, &(0x7f0000000200)={0x0, 0x0, 0x0, 0x0, "+
+		"&(0x7f0000000300)={0x0, 0x0, 0x0, 0x0, 0x0, 0x0}, 0x0}, 0x0}, 0x0})\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bug needs a list of at least 2 elements.
+	pred := func(p1 *Prog, callIndex, minimizeType int) bool {
+		return pointerDepth(p1.Calls[0].Args[0]) >= 2
+	}
+	p1, _, err := Minimize(p, 0, false, pred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "test$recur2(&(0x7f0000000000)={0x0, 0x0, 0x0, 0x0, &(0x7f0000000300)})\n"
+	if got := string(p1.Serialize()); got != want {
+		t.Fatalf("got:\n%vwant:\n%v", got, want)
+	}
+}
+
+func TestMinimizeTriviallyMinimal(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	tests := []struct {
+		prog    string
+		trivial bool
+	}{
+		{"test$int(0x0, 0x0, 0x0, 0x0, 0x0)\n", true},
+		{"test$opt0(0x0)\n", true},
+		{"test$res0()\n", true},
+		{"test$int(0x0, 0x1, 0x0, 0x0, 0x0)\n", false},
+		{"test$int(0x0, 0x0, 0x0, 0x0, 0x0) (async)\n", false},
+		{"test$res0()\ntest$int(0x0, 0x0, 0x0, 0x0, 0x0)\n", false},
+	}
+	for i, test := range tests {
+		p, err := target.Deserialize([]byte(test.prog), Strict)
+		if err != nil {
+			t.Fatalf("#%v: %v", i, err)
+		}
+		callIndex := len(p.Calls) - 1
+		executed := 0
+		pred := func(p1 *Prog, callIndex, minimizeType int) bool {
+			executed++
+			return true
+		}
+		p1, ci, stats, err := MinimizeWithStats(p, callIndex, false, pred, MinimizeOptions{})
+		if err != nil {
+			t.Fatalf("#%v: %v", i, err)
+		}
+		if test.trivial != (executed == 0) {
+			t.Errorf("#%v: trivial %v, but the predicate was executed %v times", i, test.trivial, executed)
+		}
+		if test.trivial && (p1 != p || ci != callIndex || stats != (MinimizeStats{})) {
+			t.Errorf("#%v: the trivially minimal program was changed: %+v\n%s", i, stats, p1.Serialize())
+		}
+	}
+}
+
+func TestMinimizeDetectFlaky(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	p, err := target.Deserialize([]byte("r0 = test$res0()\n"+
+		"test()\n"+
+		"test$res1(r0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := newFlakyVerdicts()
+	for i, test := range []struct {
+		p         *Prog
+		callIndex int
+		res       bool
+		flaky     bool
+	}{
+		{p, 2, true, false},
+		{p, 2, true, false},
+		{p, 1, false, false},
+		{p, 2, false, true},
+		// Every flaky candidate is counted once.
+		{p, 2, true, false},
+		{p, 2, false, false},
+		{p, 1, true, true},
+	} {
+		if got := v.record(test.p, test.callIndex, test.res); got != test.flaky {
+			t.Errorf("#%v: got flaky %v, want %v", i, got, test.flaky)
+		}
+	}
+	// A deterministic predicate is never flaky.
+	pred := func(p1 *Prog, callIndex, minimizeType int) bool {
+		return len(p1.Calls) > callIndex && p1.Calls[callIndex].Meta.Name == "test$res1"
+	}
+	_, _, stats, err := MinimizeWithStats(p, 2, false, pred, MinimizeOptions{DetectFlaky: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total == 0 || stats.Flaky != 0 {
+		t.Fatalf("got %v flaky candidates of %v", stats.Flaky, stats.Total)
+	}
+}
+
+func TestFrontRemovalCandidatesDense(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target := InitTargetTest(t, "test", "64")
+	defer func() {
+		target.SparseInfluence = nil
+	}()
+	// Every test() call influences all later test() calls, so without marking visited calls
+	// the walk would enqueue a call once per influence path, i.e. exponentially many times.
+	dense, unrelated := target.SyscallMap["test"].ID, target.SyscallMap["test$res0"].ID
+	target.SparseInfluence = map[InfluenceEdge]bool{{dense, dense}: true}
+	buf := new(strings.Builder)
+	var want []int
+	const ncalls = 200
+	for i := 0; i < ncalls; i++ {
+		if i%5 == 1 {
+			buf.WriteString("test$res0()\n")
+			want = append(want, i)
+		} else {
+			buf.WriteString("test()\n")
+		}
+	}
+	p, err := target.Deserialize([]byte(buf.String()), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Calls[ncalls-1].Meta.ID != dense || p.Calls[1].Meta.ID != unrelated {
+		t.Fatalf("bad program")
+	}
+	got := frontRemovalCandidates(p, ncalls-1, &MinimizeOptions{})
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got candidates %v, want %v", got, want)
+	}
+}
+
+func TestMinimizeIgnoreInfluencers(t *testing.T) {
+	target, err := GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("r0 = test$res0()\n"+
+		"test()\n"+
+		"test$res1(r0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := make(map[int]bool)
+	for _, c := range p.Calls {
+		calls[c.Meta.ID] = true
+	}
+	target.AnalyzeSparseInfluence(calls)
+	defer func() {
+		target.SparseInfluence = nil
+	}()
+	// The front calls can be removed only together, so only removal of all front calls at once works.
+	pred := func(p *Prog, callIndex, minimizeType int) bool {
+		return hasCall(p, "test$res0", 0, callIndex) == hasCall(p, "test", 0, callIndex)
+	}
+	tests := []struct {
+		ignore     map[string]bool
+		candidates []int
+		calls      int
+	}{
+		{nil, []int{1}, 3},
+		{map[string]bool{"test$res0": true}, []int{0, 1}, 1},
+	}
+	for i, test := range tests {
+		opts := MinimizeOptions{IgnoreInfluencers: test.ignore}
+		if got := frontRemovalCandidates(p, 2, &opts); !reflect.DeepEqual(got, test.candidates) {
+			t.Errorf("#%v: got front removal candidates %v, want %v", i, got, test.candidates)
+		}
+		p1, ci, err := MinimizeWithOptions(p, 2, false, pred, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p1.Calls) != test.calls || p1.Calls[ci].Meta.Name != "test$res1" {
+			t.Errorf("#%v: got %v calls, want %v\n%s", i, len(p1.Calls), test.calls, p1.Serialize())
+		}
+	}
+}
+
+func TestMinimizeStop(t *testing.T) {
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"getpid()\n"+
+		"getuid()\n"+
+		"pipe2(&(0x7f0000000000), 0x80000)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	calls, stopped := 0, false
+	pred := func(p *Prog, callIndex, minimizeType int) bool {
+		if stopped {
+			t.Fatalf("the predicate is invoked after stop")
+		}
+		calls++
+		if len(p.Calls) == 3 {
+			// Accept the first removal and stop.
+			close(stop)
+			stopped = true
+			return true
+		}
+		return false
+	}
+	p1, ci, err := MinimizeWithOptions(p, 3, false, pred, MinimizeOptions{Stop: stop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p1.Calls) != 3 || p1.Calls[ci].Meta.Name != "pipe2" || !stopped {
+		t.Fatalf("bad partial result after %v predicate calls: call index %v\n%s", calls, ci, p1.Serialize())
+	}
+}
+
+func TestMinimizeCollapseDuplicateCalls(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("getpid()\n"+
+		"getpid()\n"+
+		"getpid()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"+
+		"sched_yield()\n"+
+		"sched_yield()\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := make(map[int]bool)
+	for _, c := range p.Calls {
+		calls[c.Meta.ID] = true
+	}
+	target.AnalyzeSparseInfluence(calls)
+	defer func() {
+		target.SparseInfluence = nil
+	}()
+	var candidates []string
+	p1, ci, err := Minimize(p, 3, false, func(p *Prog, callIndex, minimizeType int) bool {
+		candidates = append(candidates, string(p.Serialize()))
+		return hasCall(p, "getpid", 0, callIndex) && p.Calls[callIndex].Meta.Name == "pipe2"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Runs are collapsed first, starting from the last one.
+	want := []string{
+		"getpid()\ngetpid()\ngetpid()\npipe2(&(0x7f0000000000), 0x0)\nsched_yield()\n",
+		"getpid()\npipe2(&(0x7f0000000000), 0x0)\nsched_yield()\n",
+	}
+	if len(candidates) < len(want) || !reflect.DeepEqual(candidates[:len(want)], want) {
+		t.Fatalf("got first candidates:\n%q\nwant:\n%q", candidates, want)
+	}
+	if got := string(p1.Serialize()); got != "getpid()\npipe2(0x0, 0x0)\n" || ci != 1 {
+		t.Fatalf("bad minimization result: call index %v\n%s", ci, got)
+	}
+}
+
+// BenchmarkMinimizeValidate measures the overhead of validating every minimization candidate.
+func BenchmarkMinimizeValidate(b *testing.B) {
+	target, cleanup := initBench(b)
+	defer cleanup()
+	ct := target.DefaultChoiceTable()
+	p := target.Generate(rand.NewSource(0), 30, ct)
+	callIndex := len(p.Calls) - 1
+	candidates := 0
+	pred := func(p1 *Prog, callIndex1, _ int) bool {
+		candidates++
+		return callIndex1 == len(p1.Calls)-1 && p1.Calls[callIndex1].Meta == p.Calls[callIndex].Meta
+	}
+	olddebug := debug
+	defer func() { debug = olddebug }()
+	for _, validate := range []bool{false, true} {
+		b.Run(fmt.Sprintf("validate=%v", validate), func(b *testing.B) {
+			debug = validate
+			candidates = 0
+			for i := 0; i < b.N; i++ {
+				if _, _, err := Minimize(p, callIndex, false, pred); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(candidates)/float64(b.N), "candidates/op")
+		})
+	}
+}
+
+// BenchmarkMinimizeInfluence compares influence-guided call removal with the vanilla one-by-one removal
+// on generated programs with a synthetic influence of the given edge density (in percent) between
+// their calls. The predicate accepts a candidate as long as the syscalls that transitively influence
+// the last call are present, so fewer predicates per op means fewer executions.
+func BenchmarkMinimizeInfluence(b *testing.B) {
+	target, cleanup := initBench(b)
+	defer cleanup()
+	defer func() {
+		target.SparseInfluence = nil
+	}()
+	rnd := rand.New(rand.NewSource(0))
+	for _, syscalls := range []int{100, 1000} {
+		enabled := make(map[*Syscall]bool)
+		for _, i := range rnd.Perm(len(target.Syscalls))[:syscalls] {
+			enabled[target.Syscalls[i]] = true
+		}
+		enabled, _ = target.TransitivelyEnabledCalls(enabled)
+		ct := target.BuildChoiceTable(nil, enabled)
+		for _, ncalls := range []int{10, 30} {
+			p := target.Generate(rand.NewSource(0), ncalls, ct)
+			callIndex := len(p.Calls) - 1
+			for _, density := range []int{5, 30} {
+				sparse := make(map[InfluenceEdge]bool)
+				for _, src := range p.Calls {
+					for _, dest := range p.Calls {
+						if rnd.Intn(100) < density {
+							sparse[InfluenceEdge{src.Meta.ID, dest.Meta.ID}] = true
+						}
+					}
+				}
+				target.SparseInfluence = sparse
+				required := make(map[*Syscall]bool)
+				removable := make(map[int]bool)
+				for _, i := range frontRemovalCandidates(p, callIndex, &MinimizeOptions{}) {
+					removable[i] = true
+				}
+				for i, c := range p.Calls[:callIndex] {
+					if !removable[i] {
+						required[c.Meta] = true
+					}
+				}
+				pred := func(p1 *Prog, callIndex1, _ int) bool {
+					present := make(map[*Syscall]bool)
+					for _, c := range p1.Calls[:callIndex1] {
+						present[c.Meta] = true
+					}
+					for meta := range required {
+						if !present[meta] {
+							return false
+						}
+					}
+					return p1.Calls[callIndex1].Meta == p.Calls[callIndex].Meta
+				}
+				for _, vanilla := range []bool{false, true} {
+					name := fmt.Sprintf("syscalls=%v/calls=%v/density=%v/vanilla=%v",
+						syscalls, len(p.Calls), density, vanilla)
+					b.Run(name, func(b *testing.B) {
+						target.SparseInfluence = sparse
+						preds := 0
+						countingPred := func(p1 *Prog, callIndex1, minimizeType int) bool {
+							preds++
+							return pred(p1, callIndex1, minimizeType)
+						}
+						opts := &MinimizeOptions{NoInfluence: vanilla}
+						for i := 0; i < b.N; i++ {
+							if _, _, err := removeCalls(p, callIndex, false, countingPred, opts); err != nil {
+								b.Fatal(err)
+							}
+						}
+						b.ReportMetric(float64(preds)/float64(b.N), "preds/op")
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestMinimizeStats(t *testing.T) {
+	target := InitTargetTest(t, "linux", "amd64")
+	p, err := target.Deserialize([]byte("getpid()\n"+
+		"sched_yield()\n"+
+		"pipe2(&(0x7f0000000000), 0x0) (async)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want MinimizeStats
+	_, _, stats, err := MinimizeWithStats(p, 2, false, func(p *Prog, callIndex, minimizeType int) bool {
+		want.Total++
+		switch minimizeType {
+		case 1:
+			want.Calls++
+		case 2:
+			want.Args++
+		case 3:
+			want.Props++
+		}
+		return p.Calls[callIndex].Meta.Name == "pipe2"
+	}, MinimizeOptions{NoInfluence: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != want || want.Calls == 0 || want.Args == 0 || want.Props == 0 {
+		t.Fatalf("got stats %+v, want %+v", stats, want)
+	}
+	var total MinimizeStats
+	total.Add(stats)
+	total.Add(stats)
+	if total.Total != 2*stats.Total || total.Props != 2*stats.Props {
+		t.Fatalf("bad sum of stats %+v and %+v: %+v", stats, stats, total)
+	}
+}
+
+func TestMinimizeCompValues(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	p, err := target.Deserialize([]byte("test$int(0x1, 0x2, 0x3, 0x4, 0x5)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		compValues map[uint64]bool
+		want       []string
+	}{
+		{
+			nil,
+			[]string{
+				"test$int(0x0, 0x2, 0x3, 0x4, 0x5)\n",
+				"test$int(0x0, 0x0, 0x3, 0x4, 0x5)\n",
+				"test$int(0x0, 0x0, 0x0, 0x4, 0x5)\n",
+				"test$int(0x0, 0x0, 0x0, 0x0, 0x5)\n",
+				"test$int(0x0, 0x0, 0x0, 0x0, 0x0)\n",
+			},
+		},
+		{
+			// Arguments compared by the kernel are tried last.
+			map[uint64]bool{0x1: true, 0x4: true},
+			[]string{
+				"test$int(0x1, 0x0, 0x3, 0x4, 0x5)\n",
+				"test$int(0x1, 0x0, 0x0, 0x4, 0x5)\n",
+				"test$int(0x1, 0x0, 0x0, 0x4, 0x0)\n",
+				"test$int(0x0, 0x0, 0x0, 0x4, 0x0)\n",
+				"test$int(0x0, 0x0, 0x0, 0x0, 0x0)\n",
+			},
+		},
+	} {
+		var got []string
+		_, _, err := MinimizeWithOptions(p, 0, false, func(p *Prog, callIndex, minimizeType int) bool {
+			if minimizeType == 2 {
+				got = append(got, string(p.Serialize()))
+			}
+			return true
+		}, MinimizeOptions{CompValues: test.compValues})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("comp values %v: got candidates:\n%q\nwant:\n%q", test.compValues, got, test.want)
+		}
+	}
+}
+
+func TestMinimizeRewireResource(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	p, err := target.Deserialize([]byte("r0 = test$res0()\n"+
+		"r1 = test$res0()\n"+
+		"test$res1(r1)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The consumer needs some resource, but not necessarily the one it was given.
+	p1, _, err := MinimizeWithOptions(p, 2, false, func(p *Prog, callIndex, minimizeType int) bool {
+		return p.Calls[callIndex].Args[0].(*ResultArg).Res != nil
+	}, MinimizeOptions{ArgsOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The original producer of r1 is unused now, so call removal can drop it.
+	want := "r0 = test$res0()\n" +
+		"test$res0()\n" +
+		"test$res1(r0)\n"
+	if got := string(p1.Serialize()); got != want {
+		t.Fatalf("got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestMinimizeNoPostRemove(t *testing.T) {
+	// Not parallel, because the shared target is modified.
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The calls after pipe2 use its resources, so they are not removed as unrelated calls.
+	p, err := target.Deserialize([]byte("pipe2(&(0x7f0000000000)={<r0=>0xffffffffffffffff, "+
+		"<r1=>0xffffffffffffffff}, 0x0)\n"+
+		"close(r0)\n"+
+		"close(r1)\n"+
+		"dup(r0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := make(map[int]bool)
+	for _, c := range p.Calls {
+		calls[c.Meta.ID] = true
+	}
+	target.AnalyzeSparseInfluence(calls)
+	defer func() {
+		target.SparseInfluence = nil
+	}()
+	for _, noPostRemove := range []bool{false, true} {
+		var candidates []int
+		p1, _, err := MinimizeWithOptions(p, 0, false, func(p *Prog, callIndex, minimizeType int) bool {
+			candidates = append(candidates, len(p.Calls))
+			return true
+		}, MinimizeOptions{NoPostRemove: noPostRemove})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The bulk removal is the first candidate.
+		bulk := candidates[0] == 1
+		if bulk == noPostRemove || len(p1.Calls) != 1 {
+			t.Fatalf("NoPostRemove=%v: bulk removal %v, %v calls left", noPostRemove, bulk, len(p1.Calls))
+		}
+	}
+}
+
+func TestMinimizeStable(t *testing.T) {
+	// Not parallel, because the global learning switch is modified.
+	target, err := GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"getpid()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Influence_Learning_Enable = true
+	defer func() {
+		Influence_Learning_Enable = false
+	}()
+	for _, stable := range []bool{false, true} {
+		needHashes := false
+		_, _, err := MinimizeWithOptions(p, 2, false, func(p *Prog, callIndex, minimizeType int) bool {
+			needHashes = needHashes || p.Influence_Hash_NeedUpdate
+			return false
+		}, MinimizeOptions{Stable: stable})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if needHashes == stable {
+			t.Errorf("Stable=%v: coverage hashes requested %v", stable, needHashes)
+		}
+	}
+}
+
+func TestMinimizeArgsFocus(t *testing.T) {
+	target := InitTargetTest(t, "linux", "amd64")
+	const mmap = "mmap(&(0x7f0000000000/0x1000)=nil, 0x1000, 0x3, 0x32, 0xffffffffffffffff, 0x0)\n"
+	p, err := target.Deserialize([]byte(mmap+"pipe2(&(0x7f0000001000), 0x80000)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, focus := range []bool{false, true} {
+		p1, callIndex, err := MinimizeWithOptions(p, 1, false, func(p *Prog, callIndex, minimizeType int) bool {
+			return true
+		}, MinimizeOptions{ArgsOnly: true, ArgsFocus: focus})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if callIndex != 1 || len(p1.Calls) != 2 {
+			t.Fatalf("ArgsFocus=%v: call index %v, %v calls", focus, callIndex, len(p1.Calls))
+		}
+		if flags := p1.Calls[1].Args[1].(*ConstArg).Val; flags != 0 {
+			t.Errorf("ArgsFocus=%v: pipe2 flags %#x are not minimized", focus, flags)
+		}
+		data := string(p1.Serialize())
+		if untouched := strings.HasPrefix(data, mmap); untouched != focus {
+			t.Errorf("ArgsFocus=%v: mmap args untouched %v:\n%v", focus, untouched, data)
+		}
+	}
+}
+
+func TestMinimizeCallName(t *testing.T) {
+	target := InitTargetTest(t, "linux", "amd64")
+	p, err := target.Deserialize([]byte("sched_yield()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pred := func(p *Prog, callIndex, minimizeType int) bool {
+		t.Fatalf("the predicate is invoked for a wrong call")
+		return false
+	}
+	_, _, err = MinimizeWithOptions(p, 0, false, pred, MinimizeOptions{CallName: "pipe2"})
+	if err == nil || !strings.Contains(err.Error(), "expected pipe2") {
+		t.Fatalf("got error %v, want a call name mismatch", err)
+	}
+	_, _, err = MinimizeWithOptions(p, 1, false, func(p *Prog, callIndex, minimizeType int) bool {
+		return false
+	}, MinimizeOptions{CallName: "pipe2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMinimizeFreshResources(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	p, err := target.Deserialize([]byte("r0 = test$produce_chain_src()\n"+
+		"r1 = test$produce_chain_res_from(r0)\n"+
+		"test$consume_chain_res(r1)\n"), Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every consumer needs some resource, but not necessarily the one it was given.
+	pred := func(p *Prog, callIndex, minimizeType int) bool {
+		for _, c := range p.Calls {
+			for _, arg := range c.Args {
+				if a, ok := arg.(*ResultArg); ok && a.Res == nil {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	for _, fresh := range []bool{false, true} {
+		p1, callIndex, err := MinimizeWithOptions(p, 2, false, pred, MinimizeOptions{FreshResources: fresh})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := string(p.Serialize())
+		if fresh {
+			want = "r0 = test$produce_chain_res()\n" +
+				"test$consume_chain_res(r0)\n"
+		}
+		if got := string(p1.Serialize()); got != want || callIndex != len(p1.Calls)-1 {
+			t.Errorf("FreshResources=%v: got call index %v:\n%v\nwant:\n%v", fresh, callIndex, got, want)
+		}
+	}
+}
+
+func TestMinimizeSequence(t *testing.T) {
+	target := InitTargetTest(t, "test", "64")
+	var progs []*Prog
+	for _, data := range []string{
+		"test()\ntest$res0()\n",
+		"test()\n",
+		"test()\ntest$res0()\n",
+		"test()\n",
+	} {
+		p, err := target.Deserialize([]byte(data), Strict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		progs = append(progs, p)
+	}
+	// The target call reproduces only if an earlier program has left the state set up by test$res0.
+	pred := func(seq []*Prog, targetProg, targetCall int) bool {
+		if seq[targetProg].Calls[targetCall].Meta.Name != "test$res0" {
+			return false
+		}
+		for _, p := range seq[:targetProg] {
+			for _, c := range p.Calls {
+				if c.Meta.Name == "test$res0" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	seq, targetProg, targetCall, err := MinimizeSequence(progs, 2, 1, pred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range seq {
+		got = append(got, string(p.Serialize()))
+	}
+	want := []string{"test$res0()\n", "test$res0()\n"}
+	if !reflect.DeepEqual(got, want) || targetProg != 1 || targetCall != 0 {
+		t.Fatalf("got %q, target %v/%v, want %q, target 1/0", got, targetProg, targetCall, want)
+	}
+	if len(progs[0].Calls) != 2 || len(progs[2].Calls) != 2 {
+		t.Fatalf("the original programs were modified")
+	}
+	if _, _, _, err := MinimizeSequence(progs, 4, 0, pred); err == nil {
+		t.Fatalf("no error for a bad target program")
+	}
+	if _, _, _, err := MinimizeSequence(progs, 1, 1, pred); err == nil {
+		t.Fatalf("no error for a bad target call")
+	}
+}
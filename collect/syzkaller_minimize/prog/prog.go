@@ -8,10 +8,23 @@ import (
 	"reflect"
 )
 
+// Influence_Learning_Enable enables dynamic influence learning during minimization:
+// if removal of a call is rejected and coverage of the next call changes,
+// the missing influence edge between the two calls is added to the target influence matrix.
+var Influence_Learning_Enable = false
+
 type Prog struct {
 	Target   *Target
 	Calls    []*Call
 	Comments []string
+
+	// Minimize Optimization vars for dynamic influence learning.
+	// They are only maintained if Influence_Learning_Enable is set.
+	// Influence_Hash_NeedUpdate is set by Minimize while the predicate is called for a call removal
+	// and asks it to record per-call coverage hashes of the execution with RecordCallsCovHash.
+	Influence_Hash_NeedUpdate bool
+	Minimize_ExecuteStatus    bool
+	Minimize_CallsCovHash     []uint32
 }
 
 // These properties are parsed and serialized according to the tag and the type
@@ -462,6 +475,28 @@ func (props *CallProps) ForeachProp(f func(fieldName, key string, value reflect.
 	}
 }
 
+// RecordCallsCovHash records per-call coverage hashes (see SignalHash) of a successful execution of p.
+// They are used by dynamic influence learning.
+func (p *Prog) RecordCallsCovHash(hashes []uint32) {
+	p.Minimize_ExecuteStatus = true
+	p.Minimize_CallsCovHash = hashes
+}
+
+func (p *Prog) callCovHash(idx int) uint32 {
+	if idx < 0 || idx >= len(p.Minimize_CallsCovHash) {
+		return 0
+	}
+	return p.Minimize_CallsCovHash[idx]
+}
+
+// SignalHash returns the hash of signal of a call that minimization predicates compare
+// to decide whether a candidate call behaves as the original one. The algorithm is guaranteed
+// to be stable (see TestSignalHash), since hashes may be precomputed and cached, e.g. as baselines.
+// The hash depends on the order of signal elements, nil and empty signal hash to 0.
+func SignalHash(signal []uint32) uint32 {
+	return GetHash_uint32(signal)
+}
+
 // consume code
 func GetHash_uint32(data []uint32) uint32 {
 	if data == nil || len(data) <= 0 {
@@ -195,9 +195,12 @@ func testCrossTarget(t *testing.T, target *Target, crossTargets []*Target) {
 		testCrossArchProg(t, p, crossTargets)
 		p.Mutate(rs, 20, ct, nil, nil)
 		testCrossArchProg(t, p, crossTargets)
-		p, _ = Minimize(p, -1, false, func(*Prog, int, int) bool {
+		p, _, err = Minimize(p, -1, false, func(*Prog, int, int) bool {
 			return rs.Int63()%2 == 0
 		})
+		if err != nil {
+			t.Fatal(err)
+		}
 		testCrossArchProg(t, p, crossTargets)
 	}
 }
@@ -474,3 +477,23 @@ func TestSanitizeRandom(t *testing.T) {
 		}
 	})
 }
+
+func TestSignalHash(t *testing.T) {
+	// The hashes must never change, they are cached as baselines of minimization predicates.
+	tests := []struct {
+		signal []uint32
+		hash   uint32
+	}{
+		{nil, 0},
+		{[]uint32{}, 0},
+		{[]uint32{1}, 0x9e3779ba},
+		{[]uint32{1, 2, 3}, 0xfb58d153},
+		{[]uint32{0xffffffff, 0}, 0xcd94bf9f},
+		{[]uint32{0x81000000, 0x81001234, 0x8100abcd}, 0xa481376c},
+	}
+	for i, test := range tests {
+		if got := SignalHash(test.signal); got != test.hash {
+			t.Errorf("#%v: got hash 0x%x of %v, want 0x%x", i, got, test.signal, test.hash)
+		}
+	}
+}
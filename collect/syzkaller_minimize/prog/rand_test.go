@@ -67,13 +67,16 @@ func generateProg(t *testing.T, target *Target, rs rand.Source, ct *ChoiceTable,
 			p = p1.Clone()
 		})
 	}
+	var err error
 	for _, crash := range []bool{false, true} {
-		p, _ = Minimize(p, -1, crash, func(*Prog, int, int) bool {
+		p, _, err = Minimize(p, -1, crash, func(*Prog, int, int) bool {
 			return rs.Int63()%10 == 0
 		})
+		if err != nil {
+			t.Fatal(err)
+		}
 	}
 	data := p.Serialize()
-	var err error
 	p, err = target.Deserialize(data, NonStrict)
 	if err != nil {
 		t.Fatal(err)
@@ -81,6 +81,19 @@ type Target struct {
 
 	// consume code
 	InfluenceMatrix [][]uint8
+	// SparseInfluence is used instead of InfluenceMatrix after AnalyzeSparseInfluence,
+	// it holds only edges between the analyzed syscalls.
+	SparseInfluence map[InfluenceEdge]bool
+	// influenceDirty counts edges added to the influence since it was analyzed, see InfluenceMatrixDirtyCount.
+	influenceDirty atomic.Int64
+	// influenceMu protects cells of InfluenceMatrix and SparseInfluence, which are read and updated
+	// by dynamic learning of concurrent minimizations (see addInfluence).
+	influenceMu sync.RWMutex
+	// influenceUsages are resource usages of the last static influence analysis, see InfluenceEdgeReasons.
+	influenceUsages []*resourceUsage
+	// influenceRevision is the Revision of descriptions the influence was analyzed for,
+	// empty if unknown (e.g. InfluenceMatrix is assigned directly). See CheckInfluence.
+	influenceRevision string
 }
 
 const maxSpecialPointers = 16
@@ -375,42 +388,129 @@ func (pg *Builder) Finalize() (*Prog, error) {
 
 // consume code
 func (target *Target) AnalyzeStaticInfluence() {
-	type_uses := target.calcTypeUsage()
-	target.InfluenceMatrix = make([][]uint8, len(target.Syscalls))
-	for i := range target.InfluenceMatrix {
-		target.InfluenceMatrix[i] = make([]uint8, len(target.Syscalls))
+	target.InfluenceMatrix, target.influenceUsages = target.staticInfluenceOf(nil)
+	target.SparseInfluence = nil
+	target.influenceRevision = target.Revision
+	target.influenceDirty.Store(0)
+}
+
+// AnalyzeStaticInfluenceOf is AnalyzeStaticInfluence restricted to syscalls with the given IDs
+// (e.g. a subsystem of interest): the matrix still has a row and a column per syscall, but edges
+// are computed only between the given syscalls. Other syscalls neither influence nor are influenced
+// by anything, so call removal treats them as always removable.
+func (target *Target) AnalyzeStaticInfluenceOf(calls map[int]bool) {
+	target.InfluenceMatrix, target.influenceUsages = target.staticInfluenceOf(calls)
+	target.SparseInfluence = nil
+	target.influenceRevision = target.Revision
+	target.influenceDirty.Store(0)
+}
+
+// AnalyzeSparseInfluence is a lighter alternative to AnalyzeStaticInfluence for the case when
+// only a few syscalls are used: it computes influence only between syscalls with the given IDs
+// (e.g. the ones present in the programs to minimize) and stores it in SparseInfluence
+// instead of allocating the whole NxN matrix.
+func (target *Target) AnalyzeSparseInfluence(calls map[int]bool) {
+	sparse := make(map[InfluenceEdge]bool)
+	target.influenceUsages = target.staticInfluenceEdges(calls, func(src, dest int) {
+		sparse[InfluenceEdge{src, dest}] = true
+	})
+	target.InfluenceMatrix = nil
+	target.SparseInfluence = sparse
+	target.influenceRevision = target.Revision
+	target.influenceDirty.Store(0)
+}
+
+// staticInfluence returns the influence matrix where call src influences call dest
+// if src produces a resource that dest consumes. Resources of different types are
+// connected if one is a subtype of the other (e.g. sock and sock_tcp), because
+// a sock_tcp can be passed where a sock is expected and vice versa.
+func (target *Target) staticInfluence() [][]uint8 {
+	matrix, _ := target.staticInfluenceOf(nil)
+	return matrix
+}
+
+// staticInfluenceOf returns the static influence matrix with edges only between syscalls in calls,
+// or between all syscalls if calls is nil, along with the resource usages the edges come from.
+func (target *Target) staticInfluenceOf(calls map[int]bool) ([][]uint8, []*resourceUsage) {
+	matrix := make([][]uint8, len(target.Syscalls))
+	for i := range matrix {
+		matrix[i] = make([]uint8, len(target.Syscalls))
 	}
+	usages := target.staticInfluenceEdges(calls, func(src, dest int) {
+		matrix[src][dest] = 1
+	})
+	return matrix, usages
+}
+
+// resourceUsage holds IDs of syscalls that consume and produce a resource.
+type resourceUsage struct {
+	name                  string
+	kind                  []string // nil for aux resources that are only matched by name
+	dirIn_ids, dirOut_ids []int
+}
+
+// connects returns true if a resource produced according to src can be consumed according to dest.
+func (src *resourceUsage) connects(dest *resourceUsage) bool {
+	return src == dest || src.kind != nil && dest.kind != nil && isCompatibleResourceImpl(dest.kind, src.kind, false)
+}
+
+// staticInfluenceEdges invokes add for every static influence edge (see staticInfluence)
+// between syscalls in calls, or between all syscalls if calls is nil. It returns the resource usages
+// the edges come from.
+func (target *Target) staticInfluenceEdges(calls map[int]bool, add func(src, dest int)) []*resourceUsage {
+	type_uses := target.calcTypeUsage()
 
-	count := 0
+	kinds := make(map[string][]string)
+	for _, res := range target.Resources {
+		if !target.AuxResources[res.Name] {
+			kinds[resourceUsageKey(res)] = res.Kind
+		}
+	}
+	var usages []*resourceUsage
 	for type_name, callid_dir := range type_uses {
-		dirIn_ids := []int{}
-		dirOut_ids := []int{}
 		if !strings.HasPrefix(type_name, "res") {
 			continue
 		}
+		usage := &resourceUsage{kind: kinds[type_name], name: strings.TrimPrefix(type_name, "res")}
+		if usage.kind != nil {
+			usage.name = usage.kind[len(usage.kind)-1]
+		}
 		for callid, dir := range callid_dir {
+			if calls != nil && !calls[callid] {
+				continue
+			}
 			if dir == DirIn || dir == DirInOut {
-				dirIn_ids = append(dirIn_ids, callid)
+				usage.dirIn_ids = append(usage.dirIn_ids, callid)
 			}
-			if dir == DirOut {
-				dirOut_ids = append(dirOut_ids, callid)
+			// A call that takes a resource as inout both consumes and (re)produces it.
+			if dir == DirOut || dir == DirInOut {
+				usage.dirOut_ids = append(usage.dirOut_ids, callid)
 			}
 		}
-		if len(dirOut_ids) > 0 {
-			for _, call_id_src := range dirOut_ids {
-				for _, call_id_dest := range dirIn_ids {
+		usages = append(usages, usage)
+	}
+	for _, src := range usages {
+		if len(src.dirOut_ids) == 0 {
+			continue
+		}
+		for _, dest := range usages {
+			if !src.connects(dest) {
+				continue
+			}
+			for _, call_id_src := range src.dirOut_ids {
+				for _, call_id_dest := range dest.dirIn_ids {
 					if call_id_src != call_id_dest {
-						target.InfluenceMatrix[call_id_src][call_id_dest] = 1
-						count++
-						// fmt.Printf("\n%v\n%v\n", target.Syscalls[call_id_src], target.Syscalls[call_id_dest])
+						add(call_id_src, call_id_dest)
 					}
 				}
 			}
 		}
-		// fmt.Printf("%v,%v,%v\n",type_name,len(dirOut_ids),len(dirIn_ids))
 	}
-	// fmt.Printf("Syzkaller call length %v\n", len(target.Syscalls))
-	// fmt.Printf("The number of static influence pair:%v\n", count)
+	return usages
+}
+
+func resourceUsageKey(res *ResourceDesc) string {
+	return "res-" + strings.Join(res.Kind, "-")
 }
 
 func (target *Target) calcTypeUsage() map[string]map[int]Dir {
@@ -422,11 +522,7 @@ func (target *Target) calcTypeUsage() map[string]map[int]Dir {
 			if target.AuxResources[a.Desc.Name] {
 				noteTypeUses(type_uses, c, ctx.Dir, "res%v", a.Desc.Name)
 			} else {
-				str := "res"
-				for _, k := range a.Desc.Kind {
-					str += "-" + k
-				}
-				noteTypeUses(type_uses, c, ctx.Dir, str)
+				noteTypeUses(type_uses, c, ctx.Dir, resourceUsageKey(a.Desc))
 			}
 		case *PtrType:
 			if _, ok := a.Elem.(*StructType); ok {
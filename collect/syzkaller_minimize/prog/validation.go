@@ -9,6 +9,7 @@ import (
 
 var debug = false // enabled in tests and fuzzers
 
+// Debug enables expensive consistency checks, e.g. validation of every minimization candidate.
 func Debug() {
 	debug = true
 }
@@ -147,7 +147,7 @@ func (proc *Proc) triageInput(item *WorkTriage) {
 		inputCover.Merge(thisCover)
 	}
 	if item.flags&ProgMinimized == 0 {
-		item.p, item.call = prog.Minimize(item.p, item.call, false,
+		p, call, err := prog.Minimize(item.p, item.call, false,
 			func(p1 *prog.Prog, call1 int, minimize_type_flag int) bool {
 				for i := 0; i < minimizeAttempts; i++ {
 					info := proc.execute(proc.execOptsCover, p1, ProgNormal,
@@ -172,6 +172,11 @@ func (proc *Proc) triageInput(item *WorkTriage) {
 				}
 				return false
 			})
+		if err != nil {
+			log.Logf(0, "failed to minimize %v: %v", logCallName, err)
+		} else {
+			item.p, item.call = p, call
+		}
 	}
 
 	data := item.p.Serialize()
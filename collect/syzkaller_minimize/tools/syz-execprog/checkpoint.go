@@ -0,0 +1,99 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A checkpoint file records indices of programs that were completely minimized, so that an interrupted
+// batch can be resumed. The file contains one decimal program index per line, '#' starts a comment.
+// A record is written only after minimization of the program finishes and every record ends with
+// a newline, so a final line without a newline is a torn write of an interrupted run and is ignored.
+type checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// loadCheckpoint returns indices of completed programs. A missing file means that nothing is completed.
+func loadCheckpoint(filename string) (map[int]bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[int]bool), nil
+		}
+		return nil, err
+	}
+	done, err := parseCheckpoint(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v:%w", filename, err)
+	}
+	return done, nil
+}
+
+func parseCheckpoint(data []byte) (map[int]bool, error) {
+	done := make(map[int]bool)
+	lines := strings.Split(string(data), "\n")
+	// The last element is either empty or a torn record.
+	for i, line := range lines[:len(lines)-1] {
+		if pos := strings.IndexByte(line, '#'); pos != -1 {
+			line = line[:pos]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		idx, err := strconv.Atoi(line)
+		if err != nil || idx < 0 {
+			return nil, fmt.Errorf("%v: bad program index %q", i+1, line)
+		}
+		done[idx] = true
+	}
+	return done, nil
+}
+
+func openCheckpoint(filename string) (*checkpoint, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// Drop a torn record, otherwise the next record would be appended to it.
+	data := make([]byte, stat.Size())
+	if _, err := file.ReadAt(data, 0); err != nil && err != io.EOF {
+		file.Close()
+		return nil, err
+	}
+	if end := bytes.LastIndexByte(data, '\n') + 1; end != len(data) {
+		if err := file.Truncate(int64(end)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &checkpoint{file: file}, nil
+}
+
+// markDone records the program as completely minimized.
+func (cp *checkpoint) markDone(idx int) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	_, err := fmt.Fprintf(cp.file, "%v\n", idx)
+	return err
+}
+
+func (cp *checkpoint) Close() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.file.Close()
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/google/syzkaller/pkg/cover/backend"
+	"github.com/google/syzkaller/sys/targets"
+)
+
+// symbolizer maps kernel PCs to names of the functions that contain them.
+// A nil symbolizer maps every PC to its address.
+type symbolizer struct {
+	// symbols are sorted by start address.
+	symbols []*backend.Symbol
+}
+
+func newSymbolizer(target *targets.Target, objDir string) (*symbolizer, error) {
+	impl, err := backend.Make(target, "", objDir, "", "", false, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	symbols := append([]*backend.Symbol{}, impl.Symbols...)
+	sort.Slice(symbols, func(i, j int) bool {
+		return symbols[i].Start < symbols[j].Start
+	})
+	return &symbolizer{symbols}, nil
+}
+
+func (s *symbolizer) function(pc uint64) string {
+	if s != nil {
+		i := sort.Search(len(s.symbols), func(i int) bool {
+			return s.symbols[i].End > pc
+		})
+		if i < len(s.symbols) && s.symbols[i].Start <= pc {
+			return s.symbols[i].Name
+		}
+	}
+	return fmt.Sprintf("0x%x", pc)
+}
+
+// foldCover writes coverage of a call in the folded stack format of flamegraph.pl:
+// a "root;function count" line per covered function, where count is the number of covered PCs.
+// Lines are sorted by function, so that the output is stable.
+func foldCover(buf *bytes.Buffer, root string, pcs []uint64, sym *symbolizer) {
+	counts := make(map[string]int)
+	for _, pc := range pcs {
+		counts[sym.function(pc)]++
+	}
+	funcs := make([]string, 0, len(counts))
+	for fn := range counts {
+		funcs = append(funcs, fn)
+	}
+	sort.Strings(funcs)
+	for _, fn := range funcs {
+		fmt.Fprintf(buf, "%v;%v %v\n", root, fn, counts[fn])
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// findDuplicates returns indices of programs that are identical to an earlier program (and preserve
+// the same call) mapped to the index of the first such program, which is then minimized on behalf
+// of all of them. Programs in exclude (e.g. invalid or already minimized ones) are ignored.
+func findDuplicates(progs []*programEntry, exclude map[int]bool) map[int]int {
+	first := make(map[string]int)
+	duplicateOf := make(map[int]int)
+	for idx, entry := range progs {
+		if exclude[idx] {
+			continue
+		}
+		key := fmt.Sprintf("%v\n%s", entry.callIndex, entry.p.Serialize())
+		if rep, ok := first[key]; ok {
+			duplicateOf[idx] = rep
+			continue
+		}
+		first[key] = idx
+	}
+	return duplicateOf
+}
+
+// groupDuplicates inverts the findDuplicates result: it maps a representative program
+// to its duplicates in ascending order.
+func groupDuplicates(duplicateOf map[int]int) map[int][]int {
+	duplicates := make(map[int][]int)
+	for idx := range duplicateOf {
+		duplicates[duplicateOf[idx]] = append(duplicates[duplicateOf[idx]], idx)
+	}
+	for _, dups := range duplicates {
+		sort.Ints(dups)
+	}
+	return duplicates
+}
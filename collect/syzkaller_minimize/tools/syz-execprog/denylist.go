@@ -0,0 +1,66 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Coverage of timers, interrupts and other background activity shows up in the per-call signal
+// at random and makes the per-call coverage hashes used by dynamic influence learning flaky.
+// A coverage deny list removes such signal before hashing. The list contains one signal value
+// per line (hex or decimal, '#' starts a comment). A reasonable list is the union of the signal
+// observed while executing a nop program many times, e.g.:
+//
+//	mkdir nop && echo 'getpid()' > nop/nop_0 && touch nop.out
+//	syz-execprog -programdir=nop -outpath=nop.out -repeat=100 -procs=1 -coverfile=nop.cov
+//	cat nop.cov_prog*.signal | sort -u > denylist
+//
+// The signal common to all calls (syscall entry/exit) is denied too, but it doesn't tell calls apart anyway.
+func loadCovDenylist(filename string) (map[uint32]bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	deny := make(map[uint32]bool)
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if pos := strings.IndexByte(text, '#'); pos != -1 {
+			text = text[:pos]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(text, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: bad signal value %q: %w", filename, line, text, err)
+		}
+		deny[uint32(v)] = true
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return deny, nil
+}
+
+// filterSignal returns signal without the denied values.
+func filterSignal(signal []uint32, deny map[uint32]bool) []uint32 {
+	if len(deny) == 0 {
+		return signal
+	}
+	var filtered []uint32
+	for _, sig := range signal {
+		if !deny[sig] {
+			filtered = append(filtered, sig)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/prog"
+)
+
+// executor executes programs, it is implemented by *ipc.Env.
+type executor interface {
+	Exec(opts *ipc.ExecOpts, p *prog.Prog) ([]byte, *ipc.ProgInfo, bool, error)
+}
+
+// callDiverges returns true if call callIndex executed in both infos and returned
+// a different errno or produced different coverage.
+func callDiverges(info1, info2 *ipc.ProgInfo, callIndex int) bool {
+	if !callExecuted(info1, callIndex) || !callExecuted(info2, callIndex) {
+		return false
+	}
+	inf1, inf2 := &info1.Calls[callIndex], &info2.Calls[callIndex]
+	return inf1.Errno != inf2.Errno || prog.SignalHash(inf1.Signal) != prog.SignalHash(inf2.Signal)
+}
+
+func callExecuted(info *ipc.ProgInfo, callIndex int) bool {
+	return info != nil && callIndex >= 0 && callIndex < len(info.Calls) &&
+		info.Calls[callIndex].Flags&ipc.CallExecuted != 0
+}
+
+// checkBaselineDivergence checks that the call to preserve behaves differently
+// on the two executors in the original program, see -executor2.
+func checkBaselineDivergence(info1, info2 *ipc.ProgInfo, callIndex int) error {
+	if err := checkBaselineCall(info1, callIndex); err != nil {
+		return err
+	}
+	if !callExecuted(info2, callIndex) {
+		return fmt.Errorf("call %v is not executed by the second executor", callIndex)
+	}
+	if !callDiverges(info1, info2, callIndex) {
+		return fmt.Errorf("call %v behaves the same on both executors", callIndex)
+	}
+	return nil
+}
+
+// differentialPred returns a minimization predicate that accepts a candidate as long as
+// the preserved call behaves differently on the two executors (e.g. on a patched and
+// an unpatched kernel). A candidate is executed on both up to reexec times until it diverges.
+// onExec is invoked after every pair of executions with their total duration.
+func differentialPred(env1, env2 executor, opts *ipc.ExecOpts, reexec int,
+	onExec func(p *prog.Prog, minimizeType int, elapsed time.Duration)) func(*prog.Prog, int, int) bool {
+	return func(p *prog.Prog, callIndex, minimizeType int) bool {
+		for i := 0; i < reexec; i++ {
+			start := time.Now()
+			_, info1, _, _ := env1.Exec(opts, p)
+			_, info2, _, _ := env2.Exec(opts, p)
+			if onExec != nil {
+				onExec(p, minimizeType, time.Since(start))
+			}
+			if callDiverges(info1, info2, callIndex) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -3,16 +3,27 @@
 
 // execprog executes a single program or a set of programs
 // and optionally prints information about execution.
+//
+// The executor is always forked locally via ipc.MakeEnv, pkg/ipc has no mode to talk to a remote executor.
+// To minimize programs against a kernel running in a VM, copy syz-execprog, syz-executor and the programs
+// into the VM and run execprog there (the same way syz-manager runs syz-fuzzer), e.g.:
+//
+//	syz-execprog -executor=./syz-executor -procs=4 -programdir=./progs -outpath=./result
 package main
 
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,6 +37,7 @@ import (
 	"github.com/google/syzkaller/pkg/ipc"
 	"github.com/google/syzkaller/pkg/ipc/ipcconfig"
 	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/pkg/mgrconfig"
 	"github.com/google/syzkaller/pkg/osutil"
 	"github.com/google/syzkaller/pkg/tool"
 	"github.com/google/syzkaller/prog"
@@ -61,7 +73,142 @@ var (
 	flagOutPath             = flag.String("outpath", "", "the file for saving result path")
 	flagStartIdx            = flag.Int("startidx", -1, "start index")
 	flagInfluenceProportion = flag.Int("influenceproportion", 100, "influence Proportion")
+	flagCSVOut              = flag.String("csvout", "", "write one CSV row per minimized program to the file")
+	flagSeed                = flag.Int64("seed", 0, "random seed for influence proportion and validation split "+
+		"(0 means use current time)")
+	flagValidationFraction = flag.Float64("validationfraction", 0,
+		"fraction of programs held out as a validation set and reported separately")
+	flagMaxRemovalsPerPhase = flag.Int("maxremovalsperphase", 0,
+		"max calls a single call removal phase may drop before yielding to the next one (0 for unlimited)")
+	flagPerFile = flag.Bool("perfile", false, "print minimization results aggregated per program file")
+	flagReexec  = flag.Int("reexec", 3, "number of executions used to confirm that a minimization "+
+		"candidate preserves the target call signal (higher is slower, but more accurate on flaky targets)")
+	flagDisableCalls = flag.Bool("disablecalls", false, "before removing a call whose result is used, try to "+
+		"replace it with a syscall without arguments that returns the same resource")
+	flagNoInfluence = flag.Bool("noinfluence", false, "don't use the influence matrix for minimization "+
+		"(vanilla call removal baseline for comparison)")
+	flagLearn = flag.Bool("learn", false, "learn influence between calls dynamically during minimization "+
+		"(adds edges to the influence matrix if removal of a call changes coverage of the next one)")
+	flagCovDenylist = flag.String("covdenylist", "", "file with signal values to ignore when hashing "+
+		"per-call coverage for -learn (see loadCovDenylist)")
+	flagMinOut = flag.String("minout", "", "write minimized programs to the dir as progIDX_CALLINDEX "+
+		"(can be fed back with -programdir)")
+	flagInfluenceSweep = flag.String("influencesweep", "", "comma-separated list of influence proportions "+
+		"to minimize the programs with one after another, prints a comparison table (overrides -influenceproportion, "+
+		"can't be used with -csvout)")
+	flagPasses = flag.Int("passes", 1, "max number of minimization passes, each pass minimizes the result "+
+		"of the previous one until a pass doesn't change the program")
+	flagCheckpoint = flag.String("checkpoint", "", "file recording completely minimized programs, "+
+		"an interrupted batch resumes from it (instead of parsing -outpath)")
+	flagMatchErrno = flag.Bool("matcherrno", false, "require minimized programs to preserve errno "+
+		"of the target call in addition to its coverage")
+	flagKeepCalls = flag.String("keepcalls", "", "comma-separated list of syscalls that are never removed "+
+		"during minimization")
+	flagLeakTimeout = flag.Duration("leaktimeout", 10*time.Minute, "timeout for a kernel memory leak check")
+	flagLeakFatal   = flag.Bool("leakfatal", false, "exit on a failed kernel memory leak check "+
+		"instead of logging it and continuing")
+	flagOrdered = flag.Bool("ordered", false, "write -outpath output in ascending program index order "+
+		"(programs are still minimized in parallel)")
+	flagArgsOnly     = flag.Bool("argsonly", false, "don't remove calls, only minimize arguments")
+	flagDumpSyscalls = flag.String("dumpsyscalls", "", "write JSON mapping of syscall IDs (influence matrix "+
+		"indices) to names to the file and exit")
+	flagFormat = flag.String("format", formatAuto, "format of program files: "+
+		"auto, db (corpus.db), log (execution log) or syz (a single program, e.g. a syz reproducer; "+
+		"C reproducers don't contain the program, use the corresponding syz reproducer)")
+	flagCovFormat = flag.String("covformat", coverFormatText, "format of -coverfile: "+
+		"text (one PC per line) or raw (little-endian binary PCs of the target pointer size)")
+	flagFailOut = flag.String("failout", "", "append programs skipped because the executor kept failing "+
+		"on them to the file")
+	flagMaxFailures = flag.Int("maxfailures", 10, "abort the run if more than that many programs "+
+		"fail to execute (0 for unlimited)")
+	flagSparseInfluence = flag.Bool("sparseinfluence", false, "compute influence only between syscalls "+
+		"used by the programs instead of building the full influence matrix (saves memory on big targets)")
+	flagMaxCalls = flag.Int("maxcalls", 0, "stop minimization of a program as soon as it has at most "+
+		"that many calls, arguments are not minimized then (0 for full minimization)")
+	flagDedup = flag.Bool("dedup", false, "minimize identical programs only once and attribute the result "+
+		"to all of them in -outpath")
+	flagSyscallTimes = flag.Int("syscalltimes", 0, "print that many syscalls with the highest cumulative "+
+		"execution time of programs containing them at exit (0 to disable)")
+	flagIgnoreInfluencers = flag.String("ignoreinfluencers", "", "comma-separated list of syscalls whose "+
+		"influence is ignored when deciding which calls before the target call to keep (e.g. mmap), "+
+		"so they are tried to be removed together with the calls that don't influence the target call")
+	flagRecordVerdicts = flag.String("recordverdicts", "", "record the candidate program hash and the predicate "+
+		"verdict of every minimization step to this file")
+	flagReplayVerdicts = flag.String("replayverdicts", "", "minimize the programs recorded by -recordverdicts "+
+		"using the recorded verdicts instead of executing them (requires the same programs, flags and -seed)")
+	flagParseErrors = flag.Int("parseerrors", 3, "print that many errors of program records that fail "+
+		"to parse per file (the number of such records is always printed)")
+	flagExecutor2 = flag.String("executor2", "", "path to a second executor binary (e.g. one running "+
+		"on a patched kernel); programs are minimized while the preserved call behaves differently "+
+		"(returns a different errno or produces different coverage) on the two executors")
+	flagMaxExec = flag.Int("maxexec", 0, "stop minimization of a program after that many executions "+
+		"and save the partial result (0 for no limit)")
+	flagDebugValidate = flag.Bool("debugvalidate", false, "validate every minimization candidate "+
+		"(~0.2ms per candidate, for debugging of the minimizer)")
+	flagMustHit = flag.String("musthit", "", "file with PCs (in the -coverfile text format) that the preserved "+
+		"call must cover; minimization keeps a candidate if the call covers all of them instead of "+
+		"requiring exactly the original signal")
+	flagUseHints = flag.Bool("usehints", false, "with -hints, try to reset integer arguments whose values "+
+		"are compared by the kernel only after all other arguments of the call")
+	flagGateWindow = flag.Int("gatewindow", 0, "max number of programs executing concurrently, at least -procs "+
+		"(0 for 2*procs); a larger window improves throughput on fast hosts, while a smaller one reduces "+
+		"memory usage, which matters on memory-constrained hosts")
+	flagNoPostRemove = flag.Bool("nopostremove", false, "don't try to remove all calls after the preserved "+
+		"call at once, which wastes an execution if later calls affect the preserved one")
+	flagStableMin = flag.Bool("stablemin", false, "make minimization decisions depend only on the program "+
+		"structure and the predicate verdicts, so identical input minimizes to identical output "+
+		"(disables influence learning, can't be used with -learn)")
+	flagArgsFocus = flag.Bool("argsfocus", false, "minimize arguments of the preserved call only, leaving "+
+		"arguments of other calls untouched (with -argsonly quickly cleans up just the interesting call)")
+	flagCOut = flag.String("cout", "", "write C reproducers of minimized programs to the dir as "+
+		"progIDX_CALLINDEX.c (generated with default csource options)")
+	flagFreshResources = flag.Bool("freshresources", false, "try to replace resources produced far upstream "+
+		"with fresh ones created right before the consumer, so that the upstream producers can be removed")
+	flagTopInfluencers = flag.Int("topinfluencers", 0, "print the N syscalls with the most outgoing influence "+
+		"edges (candidates for -ignoreinfluencers)")
+	flagWarmup = flag.Int("warmup", 0, "execute each program that many times before minimization and use "+
+		"the most frequent result of the preserved call as the baseline (0 or 1 for a single execution)")
+	flagTraceBFS = flag.Bool("tracebfs", false, "print why influence-guided call removal kept each front call "+
+		"as the chain of influence edges from the call to the preserved one")
+	flagMemProfileInterval = flag.Duration("memprofileinterval", 0, "also overwrite the -memprofile heap profile "+
+		"periodically during the run (0 means only at shutdown)")
+	flagSubsystem = flag.String("subsystem", "", "comma-separated list of syscall name prefixes (e.g. "+
+		"socket$,bind$,sendmsg$), influence is computed only between matching syscalls and other syscalls "+
+		"are treated as non-influencing, i.e. always removable")
+	flagVerifyRuns = flag.Int("verifyruns", 0, "re-execute each minimized program that many times and mark it "+
+		"as unstable if the preserved call doesn't behave as in the original program in most of the runs")
+	flagSanitizer = flag.String("sanitizer", "", "minimize programs that trigger a sanitizer report (kasan or kcsan) "+
+		"read from /dev/kmsg, preserving the report type and the offending access instead of the call result "+
+		"(requires -procs=1)")
+	flagReportType = flag.String("reporttype", "", "with -sanitizer, only minimize programs that trigger "+
+		"a report of this type (e.g. slab-use-after-free or data-race)")
+	flagMaxMem = flag.Int("maxmem", 0, "memory budget of workers in MB, -procs is reduced to fit "+
+		"-maxmem/-workermem workers (0 means no limit)")
+	flagWorkerMem = flag.Int("workermem", 64, "estimated memory footprint of a single worker in MB "+
+		"(executor environment and program clones), see -maxmem")
+	flagWatch = flag.Bool("watch", false, "after minimizing the initial programs, keep polling -programdir "+
+		"and minimize programs from new files until interrupted (write programs to hidden files and rename "+
+		"them once complete; programs from new files are not deduplicated with -dedup)")
+	flagPredicate = flag.String("predicate", defaultPredicate, "name of the predicate that decides whether "+
+		"a candidate preserves the behavior of the program (predicates are compiled in, see predicate.go)")
+	flagCovFolded = flag.Bool("covfolded", false, "with -coverfile, also write coverage of each program "+
+		"as folded stacks (syscall;function count) for flamegraph.pl to <coverfile>_progN.folded")
+	flagKernelObj = flag.String("kernelobj", "", "directory with the kernel object file (vmlinux) "+
+		"to symbolize -covfolded, raw PCs are used without it")
+	flagForceSync = flag.Bool("forcesync", false, "strip async, rerun and fault injection from all calls "+
+		"of minimized programs and report programs that don't reproduce without them as nondeterministic")
+	flagDetectFlaky = flag.Bool("detectflaky", false, "remember verdicts of all candidates and warn about "+
+		"candidates that got both verdicts, i.e. about a flaky executor or predicate")
+	flagQuiet = flag.Bool("quiet", false, "suppress informational messages (progress, per-program and per-call "+
+		"details) at all -vv levels, only warnings, errors, explicitly requested output (e.g. -output) "+
+		"and the final stats are printed")
 )
+
+const (
+	coverFormatText = "text"
+	coverFormatRaw  = "raw"
+)
+
 var file_path_ary []string
 var call_index_ary []int
 var index_map = make(map[int]bool)
@@ -72,7 +219,19 @@ func main() {
 		flag.PrintDefaults()
 		csource.PrintAvailableFeaturesFlags()
 	}
+	// A run aborted because of -maxfailures exits with an error only after the deferred
+	// closing of the output files, so that nothing they buffer is lost.
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
 	defer tool.Init()()
+	if *flagDumpSyscalls != "" {
+		dumpSyscalls(*flagDumpSyscalls)
+		return
+	}
 	if len(flag.Args()) == 0 {
 		flag.Usage()
 		os.Exit(1)
@@ -82,83 +241,288 @@ func main() {
 		log.Fatalf("%v", err)
 	}
 
-	// read
-	file, err := os.Open(*flagOutPath)
-	if err != nil {
-		fmt.Println("erroe:", err)
-		return
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "idx") || strings.Contains(line, ",") {
-			continue
-		} else {
-			trimmedLine := strings.TrimSpace(line)
-			index, _ := strconv.Atoi(trimmedLine)
-			fmt.Printf("%v,", index)
-			index_map[index] = true
+	if *flagCheckpoint != "" {
+		index_map, err = loadCheckpoint(*flagCheckpoint)
+		if err != nil {
+			log.Fatalf("failed to load checkpoint: %v", err)
+		}
+		infof(0, "resuming: %v programs are already minimized", len(index_map))
+	} else {
+		// read
+		file, err := os.Open(*flagOutPath)
+		if err != nil {
+			log.Logf(0, "failed to open -outpath file: %v", err)
+			return
+		}
+		defer file.Close()
+		if index_map, err = parseOutPath(file); err != nil {
+			log.Fatalf("failed to read -outpath file: %v", err)
 		}
 	}
 
 	if *flagProgramDirPath != "" {
 		files, err := os.ReadDir(*flagProgramDirPath)
 		if err != nil {
-			fmt.Printf("Error:\n%v\n", *flagProgramDirPath, err)
+			log.Logf(0, "failed to read program dir %v: %v", *flagProgramDirPath, err)
 			return
 		}
 		for _, file := range files {
 			if !file.IsDir() {
 				file_path_ary = append(file_path_ary, *flagProgramDirPath+"/"+file.Name())
-				call_index, _ := strconv.Atoi(strings.Split(file.Name(), "_")[1])
+				// Programs may specify the call index with an annotation instead, see annotatedCallIndex.
+				call_index, err := parseCallIndex(file.Name())
+				if err != nil {
+					log.Logf(1, "%v", err)
+				}
 				call_index_ary = append(call_index_ary, call_index)
 			}
 		}
-		fmt.Printf("array_length:%v,%v\n%v\n", len(file_path_ary), len(call_index_ary), call_index_ary)
 	}
-	fmt.Printf("array_length:%v,%v\n%v\n", len(file_path_ary), len(call_index_ary), call_index_ary)
+	infof(1, "program files: %v, call indices: %v", len(file_path_ary), len(call_index_ary))
+	infof(2, "call indices: %v", call_index_ary)
 
 	target, err := prog.GetTarget(*flagOS, *flagArch)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
-	// consume code
-	target.AnalyzeStaticInfluence()
-	if *flagInfluenceProportion != 0 && *flagInfluenceProportion != 100 {
-		var onesCoords []struct{ row, col int }
-		for i := range target.InfluenceMatrix {
-			for j := range target.InfluenceMatrix[i] {
-				if target.InfluenceMatrix[i][j] == 1 {
-					onesCoords = append(onesCoords, struct{ row, col int }{i, j})
-				}
-			}
+	seed := *flagSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	if *flagLearn && *flagNoInfluence {
+		log.Fatalf("-learn requires the influence matrix and can't be used with -noinfluence")
+	}
+	if *flagLearn && (*flagRecordVerdicts != "" || *flagReplayVerdicts != "") {
+		log.Fatalf("-learn makes minimization depend on execution order and can't be used " +
+			"with -recordverdicts or -replayverdicts")
+	}
+	if *flagLearn && *flagStableMin {
+		log.Fatalf("-stablemin disables influence learning and can't be used with -learn")
+	}
+	if *flagLearn && *flagExecutor2 != "" {
+		log.Fatalf("-learn can't be used with -executor2")
+	}
+	if *flagUseHints && !*flagHints {
+		log.Fatalf("-usehints requires -hints")
+	}
+	// Replay doesn't have the comparison operands, which change the order of argument candidates.
+	if *flagUseHints && (*flagRecordVerdicts != "" || *flagReplayVerdicts != "") {
+		log.Fatalf("-usehints can't be used with -recordverdicts or -replayverdicts")
+	}
+	var klog kernelLog
+	if *flagSanitizer != "" {
+		if *flagSanitizer != sanitizerKASAN && *flagSanitizer != sanitizerKCSAN {
+			log.Fatalf("unknown -sanitizer %q, must be %v or %v", *flagSanitizer, sanitizerKASAN, sanitizerKCSAN)
 		}
-		numToZero := len(onesCoords) / 100 * (100 - *flagInfluenceProportion)
-		if numToZero == 0 {
-			return
+		if *flagExecutor2 != "" || *flagMustHit != "" || *flagLearn {
+			log.Fatalf("-sanitizer can't be used with -executor2, -musthit and -learn")
 		}
-		rand.Seed(time.Now().UnixNano())
-		for _, idx := range rand.Perm(len(onesCoords))[:numToZero] {
-			coord := onesCoords[idx]
-			target.InfluenceMatrix[coord.row][coord.col] = 0
+		// Sanitizer reports are minimized as crashes, which skips some argument simplifications,
+		// while replay always minimizes without crash, so such verdicts would never replay.
+		if *flagRecordVerdicts != "" {
+			log.Fatalf("-sanitizer can't be used with -recordverdicts")
+		}
+		// The kernel log is shared, so with several procs a report can't be attributed to a program.
+		if *flagProcs != 1 {
+			log.Fatalf("-sanitizer requires -procs=1")
 		}
+		if klog, err = openKernelLog(); err != nil {
+			log.Fatalf("-sanitizer: %v", err)
+		}
+	} else if *flagReportType != "" {
+		log.Fatalf("-reporttype requires -sanitizer")
 	}
-
-	count := 0
-	for i := 0; i < len(target.InfluenceMatrix); i++ {
-		for j := 0; j < len(target.InfluenceMatrix); j++ {
-			if target.InfluenceMatrix[i][j] == 1 {
-				count++
-			}
+	pred, err := lookupPredicate(*flagPredicate)
+	if err != nil {
+		log.Fatalf("-predicate: %v", err)
+	}
+	if *flagPredicate != defaultPredicate && (*flagExecutor2 != "" || *flagMustHit != "" || *flagSanitizer != "") {
+		log.Fatalf("-predicate can't be used with -executor2, -musthit and -sanitizer")
+	}
+	var mustHit map[uint64]bool
+	if *flagMustHit != "" {
+		if *flagExecutor2 != "" || *flagHints {
+			log.Fatalf("-musthit can't be used with -executor2 and -hints")
+		}
+		if mustHit, err = loadMustHit(*flagMustHit); err != nil {
+			log.Fatalf("failed to load -musthit PCs: %v", err)
+		}
+	}
+	if *flagRecordVerdicts != "" && *flagReplayVerdicts != "" {
+		log.Fatalf("-recordverdicts can't be used with -replayverdicts")
+	}
+	prog.Influence_Learning_Enable = *flagLearn
+	if *flagDebugValidate {
+		prog.Debug()
+	}
+	var sweep []int
+	if *flagInfluenceSweep != "" {
+		if *flagNoInfluence {
+			log.Fatalf("-influencesweep requires the influence matrix and can't be used with -noinfluence")
+		}
+		if *flagCSVOut != "" {
+			log.Fatalf("-csvout can't be used with -influencesweep, rows of different proportions " +
+				"can't be told apart")
+		}
+		if sweep, err = parseInfluenceSweep(*flagInfluenceSweep); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	var covDenylist map[uint32]bool
+	if *flagCovDenylist != "" {
+		covDenylist, err = loadCovDenylist(*flagCovDenylist)
+		if err != nil {
+			log.Fatalf("failed to load coverage deny list: %v", err)
 		}
+		infof(0, "loaded %v denied signal values", len(covDenylist))
+	}
+	if *flagArgsOnly && (*flagDisableCalls || *flagKeepCalls != "") {
+		log.Fatalf("-disablecalls and -keepcalls affect call removal and can't be used with -argsonly")
+	}
+	keepCalls, err := parseSyscallList(target, *flagKeepCalls)
+	if err != nil {
+		log.Fatalf("-keepcalls: %v", err)
+	}
+	ignoreInfluencers, err := parseSyscallList(target, *flagIgnoreInfluencers)
+	if err != nil {
+		log.Fatalf("-ignoreinfluencers: %v", err)
+	}
+	if *flagSparseInfluence && sweep != nil {
+		log.Fatalf("-influencesweep can't be used with -sparseinfluence")
+	}
+	subsystem, err := parseSubsystem(target, *flagSubsystem)
+	if err != nil {
+		log.Fatalf("-subsystem: %v", err)
+	}
+	if subsystem != nil && *flagNoInfluence {
+		log.Fatalf("-subsystem restricts influence and can't be used with -noinfluence")
 	}
-	fmt.Printf("after influence_proportion:%v,%v\n", count, *flagInfluenceProportion)
 
 	progs := loadPrograms_comsume(target)
-	if len(progs) == 0 {
+	if len(progs) == 0 && !*flagWatch {
+		return
+	}
+	if *flagNoInfluence {
+		infof(0, "influence matrix is disabled, using vanilla call removal")
+	} else {
+		switch {
+		case *flagSparseInfluence:
+			calls := usedSyscalls(progs)
+			if subsystem != nil {
+				for id := range calls {
+					if !subsystem[id] {
+						delete(calls, id)
+					}
+				}
+			}
+			target.AnalyzeSparseInfluence(calls)
+		case subsystem != nil:
+			target.AnalyzeStaticInfluenceOf(subsystem)
+		default:
+			target.AnalyzeStaticInfluence()
+		}
+		infof(1, "static influence: %v edges between %v syscalls",
+			influenceEdges(target), len(target.Syscalls))
+		logPeakRSS(memProfileVerbosity(), "after influence analysis")
+		if sweep == nil {
+			applyInfluenceProportion(target, *flagInfluenceProportion, rnd)
+		}
+		if *flagTopInfluencers > 0 {
+			printTopInfluencers(os.Stdout, target, *flagTopInfluencers)
+		}
+	}
+	invalid := validateCallIndices(progs)
+	if len(invalid) != 0 {
+		log.Logf(0, "skipping %v of %v programs with a bad call index", len(invalid), len(progs))
+	}
+	skipped := make(map[int]bool)
+	for idx := range invalid {
+		skipped[idx] = true
+	}
+	var duplicateOf map[int]int
+	if *flagDedup {
+		// Programs minimized by a previous run can't represent their duplicates.
+		exclude := make(map[int]bool)
+		for idx := range progs {
+			exclude[idx] = invalid[idx] || index_map[idx]
+		}
+		duplicateOf = findDuplicates(progs, exclude)
+		infof(0, "collapsed %v duplicate programs", len(duplicateOf))
+		for idx := range duplicateOf {
+			skipped[idx] = true
+		}
+	}
+	if *flagReexec < 1 {
+		log.Fatalf("-reexec must be at least 1")
+	}
+	switch *flagFormat {
+	case formatAuto, formatDB, formatLog, formatSyz:
+	default:
+		log.Fatalf("unknown -format %q", *flagFormat)
+	}
+	if *flagCovFormat != coverFormatText && *flagCovFormat != coverFormatRaw {
+		log.Fatalf("unknown -covformat %q, want %v or %v", *flagCovFormat, coverFormatText, coverFormatRaw)
+	}
+	if *flagCovFolded && *flagCoverFile == "" {
+		log.Fatalf("-covfolded requires -coverfile")
+	}
+	if *flagMaxCalls < 0 {
+		log.Fatalf("-maxcalls must not be negative")
+	}
+	if *flagMaxCalls != 0 && *flagArgsOnly {
+		log.Fatalf("-maxcalls limits call removal and can't be used with -argsonly")
+	}
+	if *flagWatch && (*flagProgramDirPath == "" || *flagRepeat != 1 || *flagSparseInfluence || sweep != nil) {
+		log.Fatalf("-watch requires -programdir and -repeat=1 and can't be used with -sparseinfluence " +
+			"and -influencesweep")
+	}
+	if *flagMaxMem < 0 || *flagWorkerMem <= 0 {
+		log.Fatalf("-maxmem must not be negative and -workermem must be positive")
+	}
+	if *flagMaxMem != 0 {
+		procs := memoryLimitedProcs(*flagProcs, *flagMaxMem, *flagWorkerMem)
+		infof(0, "using %v workers (-procs %v, -maxmem %v MB, %v MB per worker)",
+			procs, *flagProcs, *flagMaxMem, *flagWorkerMem)
+		*flagProcs = procs
+	}
+	gateWindow := *flagGateWindow
+	if gateWindow == 0 {
+		gateWindow = 2 * *flagProcs
+	}
+	if gateWindow < *flagProcs {
+		log.Fatalf("-gatewindow must be at least -procs (%v)", *flagProcs)
+	}
+	if *flagMaxExec < 0 {
+		log.Fatalf("-maxexec must not be negative")
+	}
+	if *flagSyscallTimes < 0 {
+		log.Fatalf("-syscalltimes must not be negative")
+	}
+	if *flagMaxFailures < 0 {
+		log.Fatalf("-maxfailures must not be negative")
+	}
+	if *flagWarmup < 0 {
+		log.Fatalf("-warmup must not be negative")
+	}
+	if *flagVerifyRuns < 0 {
+		log.Fatalf("-verifyruns must not be negative")
+	}
+	if *flagMemProfileInterval != 0 && memProfileFile() == "" {
+		log.Fatalf("-memprofileinterval requires -memprofile")
+	}
+	if *flagPasses < 1 {
+		log.Fatalf("-passes must be at least 1")
+	}
+	if *flagValidationFraction < 0 || *flagValidationFraction > 1 {
+		log.Fatalf("-validationfraction must be within [0, 1]")
+	}
+	validation := splitValidation(len(progs), *flagValidationFraction, seed)
+	if len(validation) != 0 {
+		infof(0, "holding out %v of %v programs for validation (seed %v)", len(validation), len(progs), seed)
+	}
+	if *flagReplayVerdicts != "" {
+		replayVerdicts(*flagReplayVerdicts, progs, newMinimizeOptions(keepCalls, ignoreInfluencers, nil), *flagPasses)
 		return
 	}
 	features, err := host.Check(target)
@@ -171,60 +535,297 @@ func main() {
 		}
 	}
 	if *flagCollide {
-		log.Logf(0, "note: setting -collide to true is deprecated now and has no effect")
+		infof(0, "note: setting -collide to true is deprecated now and has no effect")
 	}
 	config, execOpts := createConfig(target, features, featuresFlags)
 	if err = host.Setup(target, features, featuresFlags, config.Executor); err != nil {
 		log.Fatal(err)
 	}
+	var config2 *ipc.Config
+	if *flagExecutor2 != "" {
+		config2 = new(ipc.Config)
+		*config2 = *config
+		config2.Executor = *flagExecutor2
+	}
+	var verdicts *verdictRecorder
+	if *flagRecordVerdicts != "" {
+		if verdicts, err = openVerdictRecorder(*flagRecordVerdicts); err != nil {
+			log.Fatalf("failed to create verdicts file: %v", err)
+		}
+		defer verdicts.Close()
+	}
+	var out *outFile
+	if *flagOutPath != "" && sweep == nil {
+		md := &runMetadata{
+			OS:                  target.OS,
+			Arch:                target.Arch,
+			Revision:            target.Revision,
+			Seed:                seed,
+			InfluenceProportion: *flagInfluenceProportion,
+			NoInfluence:         *flagNoInfluence,
+			Learn:               *flagLearn,
+			Reexec:              *flagReexec,
+		}
+		out, err = openOutFile(*flagOutPath, 5*time.Second, md.header())
+		if err != nil {
+			log.Fatalf("failed to open -outpath file: %v", err)
+		}
+		defer out.Close()
+	}
 	var gateCallback func()
 	if features[host.FeatureLeak].Enabled {
 		gateCallback = func() {
-			output, err := osutil.RunCmd(10*time.Minute, "", config.Executor, "leak")
-			if err != nil {
+			output, err := osutil.RunCmd(*flagLeakTimeout, "", config.Executor, "leak")
+			if err == nil {
+				return
+			}
+			if *flagLeakFatal {
 				os.Stdout.Write(output)
+				if out != nil {
+					out.Close()
+				}
 				os.Exit(1)
 			}
+			// A leak (or a hung check) shouldn't throw away the rest of the batch.
+			log.Logf(0, "leak check failed: %v\n%s", err, output)
+		}
+	}
+	var csvOut *csvWriter
+	if *flagCSVOut != "" {
+		csvOut, err = openCSV(*flagCSVOut)
+		if err != nil {
+			log.Fatalf("failed to open csv output: %v", err)
+		}
+		defer csvOut.Close()
+	}
+	failures, err := openFailureLog(*flagFailOut, *flagMaxFailures)
+	if err != nil {
+		log.Fatalf("failed to open -failout file: %v", err)
+	}
+	defer failures.Close()
+	var ckpt *checkpoint
+	if *flagCheckpoint != "" && sweep == nil {
+		ckpt, err = openCheckpoint(*flagCheckpoint)
+		if err != nil {
+			log.Fatalf("failed to open checkpoint: %v", err)
+		}
+		defer ckpt.Close()
+	}
+	if *flagMinOut != "" {
+		if err := osutil.MkdirAll(*flagMinOut); err != nil {
+			log.Fatalf("failed to create minout dir: %v", err)
 		}
 	}
+	var cOpts *csource.Options
+	if *flagCOut != "" {
+		if err := osutil.MkdirAll(*flagCOut); err != nil {
+			log.Fatalf("failed to create cout dir: %v", err)
+		}
+		opts := defaultCSourceOpts(target)
+		cOpts = &opts
+	}
 	sysTarget := targets.Get(*flagOS, *flagArch)
+	var sym *symbolizer
+	if *flagCovFolded && *flagKernelObj != "" {
+		if sym, err = newSymbolizer(sysTarget, *flagKernelObj); err != nil {
+			log.Logf(0, "failed to symbolize coverage, using raw PCs: %v", err)
+		}
+	}
 	upperBase := getKernelUpperBase(sysTarget)
-	ctx := &Context{
-		progs:     progs,
-		config:    config,
-		execOpts:  execOpts,
-		gate:      ipc.NewGate(2**flagProcs, gateCallback),
-		shutdown:  make(chan struct{}),
-		repeat:    *flagRepeat,
-		target:    sysTarget,
-		upperBase: upperBase,
+	// The run is stopped either by an interrupt or by too many failures, see recordFailure.
+	interrupt := make(chan struct{})
+	osutil.HandleInterrupts(interrupt)
+	shutdown := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() { close(shutdown) })
 	}
-	var wg sync.WaitGroup
-	wg.Add(*flagProcs)
-	for p := 0; p < *flagProcs; p++ {
-		pid := p
-		go func() {
-			defer wg.Done()
-			ctx.run(pid)
-		}()
+	go func() {
+		<-interrupt
+		stop()
+	}()
+	newContext := func(out *outFile, minimized map[int]bool) *Context {
+		var ordered *orderedOutput
+		if *flagOrdered {
+			ordered = newOrderedOutput()
+		}
+		var times *syscallTimes
+		if *flagSyscallTimes != 0 {
+			times = newSyscallTimes()
+		}
+		return &Context{
+			progs:             progs,
+			config:            config,
+			config2:           config2,
+			execOpts:          execOpts,
+			gate:              ipc.NewGate(gateWindow, gateCallback),
+			shutdown:          shutdown,
+			stop:              stop,
+			out:               out,
+			checkpoint:        ckpt,
+			procs:             *flagProcs,
+			repeat:            *flagRepeat,
+			reexec:            *flagReexec,
+			matchErrno:        *flagMatchErrno,
+			keepCalls:         keepCalls,
+			ignoreInfluencers: ignoreInfluencers,
+			verdicts:          verdicts,
+			ordered:           ordered,
+			failures:          failures,
+			times:             times,
+			passes:            *flagPasses,
+			target:            sysTarget,
+			upperBase:         upperBase,
+			coverFormat:       *flagCovFormat,
+			csvOut:            csvOut,
+			validation:        validation,
+			invalid:           invalid,
+			duplicateOf:       duplicateOf,
+			duplicates:        groupDuplicates(duplicateOf),
+			covDenylist:       covDenylist,
+			mustHit:           mustHit,
+			cOpts:             cOpts,
+			sanitizer:         *flagSanitizer,
+			reportType:        *flagReportType,
+			klog:              klog,
+			predicate:         pred,
+			covFolded:         *flagCovFolded,
+			symbolizer:        sym,
+			minimized:         minimized,
+			progress: progress{
+				total: pendingPrograms(len(progs), *flagRepeat, minimized, skipped),
+				procs: *flagProcs,
+			},
+		}
+	}
+	if sweep != nil {
+		// Every sweep point minimizes all programs regardless of what previous runs did,
+		// and doesn't record them as minimized, so that a sweep doesn't affect a subsequent run.
+		points := runInfluenceSweep(target, sweep, rnd, func() *Context {
+			return newContext(nil, make(map[int]bool))
+		})
+		printInfluenceSweep(os.Stdout, points)
+		return
+	}
+	ctx := newContext(out, index_map)
+	if *flagMemProfileInterval > 0 {
+		go writeHeapProfilePeriodically(memProfileFile(), *flagMemProfileInterval, ctx.shutdown)
+	}
+	ctx.runWorkers()
+	if *flagWatch && !ctx.interrupted() {
+		ctx.watch(target)
+	}
+	ctx.drainOut()
+	logPeakRSS(memProfileVerbosity(), "after minimization")
+	ctx.summary.print(os.Stdout)
+	if len(validation) != 0 {
+		ctx.splitStats.print(os.Stdout)
+	}
+	if *flagPerFile {
+		ctx.fileStats.print(os.Stdout)
+	}
+	if ctx.times != nil {
+		ctx.times.print(os.Stdout, *flagSyscallTimes)
+	}
+	if *flagLearn {
+		log.Logf(0, "influence learning added %v edges", target.InfluenceMatrixDirtyCount())
+		if err := target.ValidateInfluenceMatrix(); err != nil {
+			log.Logf(0, "influence is corrupted after learning: %v", err)
+		}
+	}
+	if n := failures.failures(); n != 0 {
+		log.Logf(0, "%v programs failed to execute", n)
+	}
+	if failures.exceeded() {
+		log.Logf(0, "aborted: more than %v programs failed to execute", failures.max)
+		exitCode = 1
 	}
-	osutil.HandleInterrupts(ctx.shutdown)
-	wg.Wait()
 }
 
 type Context struct {
-	progs     []*prog.Prog
+	progs     []*programEntry
 	config    *ipc.Config
 	execOpts  *ipc.ExecOpts
 	gate      *ipc.Gate
 	shutdown  chan struct{}
+	stop      func() // closes shutdown, safe to call several times
 	logMu     sync.Mutex
 	posMu     sync.Mutex
+	out       *outFile
+	procs     int
 	repeat    int
+	reexec    int
+	passes    int
 	pos       int
 	lastPrint time.Time
 	target    *targets.Target
 	upperBase uint32
+	csvOut    *csvWriter
+	// validation holds indices of programs reserved for the validation set.
+	validation map[int]bool
+	splitStats splitStats
+	// invalid holds indices of programs that can't be minimized because of a bad call index.
+	invalid   map[int]bool
+	fileStats fileStats
+	progress  progress
+	// covDenylist holds noisy signal excluded from per-call coverage hashes.
+	covDenylist map[uint32]bool
+	// minimized holds indices of programs already minimized by this or a previous run, protected by posMu.
+	minimized map[int]bool
+	// checkpoint records completed programs, nil if not used.
+	checkpoint *checkpoint
+	// coverFormat is the format of coverage files, see formatCover.
+	coverFormat string
+	// matchErrno requires the target call to keep its errno, see -matcherrno.
+	matchErrno bool
+	// keepCalls holds names of syscalls that minimization must not remove, see -keepcalls.
+	keepCalls map[string]bool
+	// ordered reorders -outpath output, nil unless -ordered.
+	ordered *orderedOutput
+	// failures accounts programs the executor failed on.
+	failures *failureLog
+	// duplicateOf maps a duplicate program to its representative, duplicates holds the reverse mapping.
+	// Both are empty unless -dedup.
+	duplicateOf map[int]int
+	duplicates  map[int][]int
+	// times accumulates execution time per syscall, nil unless -syscalltimes.
+	times *syscallTimes
+	// ignoreInfluencers holds names of syscalls whose influence is ignored, see -ignoreinfluencers.
+	ignoreInfluencers map[string]bool
+	// verdicts records predicate verdicts, nil unless -recordverdicts.
+	verdicts *verdictRecorder
+	// config2 is the config of the second executor for differential minimization, nil unless -executor2.
+	config2 *ipc.Config
+	// mustHit holds PCs the preserved call must cover, nil unless -musthit.
+	mustHit map[uint64]bool
+	// summary aggregates results of the whole run, it is printed at shutdown.
+	summary runSummary
+	// cOpts are options of C reproducers of minimized programs, nil unless -cout.
+	cOpts *csource.Options
+	// sanitizer is the sanitizer whose report is preserved instead of the call result, see -sanitizer.
+	sanitizer  string
+	reportType string
+	// klog is the kernel log sanitizer reports are read from, nil unless -sanitizer.
+	klog kernelLog
+	// predicate decides whether a candidate preserves the call result, see -predicate.
+	predicate predicate
+	// covFolded enables folded coverage files, see -covfolded.
+	covFolded bool
+	// symbolizer maps PCs in folded coverage to functions, nil if -kernelobj is not given.
+	symbolizer *symbolizer
+}
+
+func (ctx *Context) runWorkers() {
+	var wg sync.WaitGroup
+	wg.Add(ctx.procs)
+	for p := 0; p < ctx.procs; p++ {
+		pid := p
+		go func() {
+			defer wg.Done()
+			ctx.run(pid)
+		}()
+	}
+	wg.Wait()
 }
 
 func (ctx *Context) run(pid int) {
@@ -233,6 +834,13 @@ func (ctx *Context) run(pid int) {
 		log.Fatalf("failed to create ipc env: %v", err)
 	}
 	defer env.Close()
+	var env2 *ipc.Env
+	if ctx.config2 != nil {
+		if env2, err = ipc.MakeEnv(ctx.config2, pid); err != nil {
+			log.Fatalf("failed to create ipc env for -executor2: %v", err)
+		}
+		defer env2.Close()
+	}
 	for {
 		select {
 		case <-ctx.shutdown:
@@ -240,67 +848,361 @@ func (ctx *Context) run(pid int) {
 		default:
 		}
 		idx := ctx.getProgramIndex()
-		if index_map[idx] == true {
-			fmt.Println("skip idx")
-			continue
-		}
 		if ctx.repeat > 0 && idx >= len(ctx.progs)*ctx.repeat {
 			return
 		}
-		entry := ctx.progs[idx%len(ctx.progs)]
-
-		// fmt.Printf("%d\n%s\n\n", idx, entry.Serialize())
-		fmt.Printf("now is executed:%d\n", idx)
-		// consume code: execute minimize and record minimize count
-		info_old := ctx.execute_consume(pid, env, entry, idx)
-		// fmt.Printf("%d,\n%v,\n%v,\n%s\n", idx, file_path_ary[idx], call_index_ary[idx], entry)
-		if info_old != nil {
-			call_index_hash := prog.GetHash_uint32(info_old.Calls[call_index_ary[idx]].Signal)
-
-			// minimize
-			index_map[idx] = true
-			out_content := fmt.Sprintf("%v\n", idx) //mark
-			AppendToFile(*flagOutPath, out_content)
-
-			minimize_call_count := 0
-			minimize_arg_count := 0
-			minimize_total_count := 0
-			prog.Minimize(entry, call_index_ary[idx], false,
-				func(p1 *prog.Prog, call1 int, minimize_type_flag int) bool {
-					for i := 0; i < 3; i++ {
-						_, info, _, _ := env.Exec(ctx.execOpts, p1)
-						minimize_total_count++
-						// consume code
-						if minimize_type_flag == 1 { // call-level minimization
-							minimize_call_count++
-						}
-						if minimize_type_flag == 2 { //arg-level minimization
-							minimize_arg_count++
-						}
-
-						if !reexecutionSuccess(info) {
-							// The call was not executed or failed.
-							continue
-						}
-						// fmt.Printf("hash info: %v,%v\n", call_index_hash, prog.GetHash_uint32(info.Calls[call1].Signal))
-						if prog.GetHash_uint32(info.Calls[call1].Signal) == call_index_hash {
-							return true
-						}
+		ctx.minimizeProgram(pid, env, env2, idx)
+		ctx.finishOut(idx)
+	}
+}
+
+// minimizeProgram minimizes program idx and records the results.
+// If env2 is not nil, the program is minimized against the two executors, see differentialPred.
+func (ctx *Context) minimizeProgram(pid int, env, env2 *ipc.Env, idx int) {
+	if ctx.isMinimized(idx) {
+		infof(2, "skipping already minimized program %v", idx)
+		return
+	}
+	if ctx.invalid[idx%len(ctx.progs)] {
+		return
+	}
+	if rep, ok := ctx.duplicateOf[idx%len(ctx.progs)]; ok {
+		infof(2, "skipping program %v, duplicate of %v", idx, rep)
+		return
+	}
+	entry := ctx.progs[idx%len(ctx.progs)]
+
+	// fmt.Printf("%d\n%s\n\n", idx, entry.p.Serialize())
+	infof(1, "minimizing program %v", idx)
+	// consume code: execute minimize and record minimize count
+	executionFailed := func(err error) {
+		select {
+		case <-ctx.shutdown:
+			// The program is not at fault, an interrupted run leaves it for the next one.
+		default:
+			ctx.recordFailure(idx, entry.file, err)
+		}
+	}
+	info_old, output_old, err := ctx.execute_consume(pid, env, entry.p, idx)
+	if err != nil {
+		executionFailed(err)
+		return
+	}
+	unstableBaseline := false
+	if *flagWarmup > 1 {
+		infos := []*ipc.ProgInfo{info_old}
+		outputs := map[*ipc.ProgInfo][]byte{info_old: output_old}
+		for len(infos) < *flagWarmup {
+			info, output, err := ctx.execute_consume(pid, env, entry.p, idx)
+			if err != nil {
+				executionFailed(err)
+				return
+			}
+			infos = append(infos, info)
+			outputs[info] = output
+		}
+		info_old, unstableBaseline = stableBaseline(infos, entry.callIndex)
+		output_old = outputs[info_old]
+		if unstableBaseline {
+			log.Logf(0, "program %v (%v): call %v behaves differently across %v executions, "+
+				"using the most frequent result as the baseline", idx, entry.file, entry.callIndex, len(infos))
+		}
+	}
+	baselineErr := checkBaselineCall(info_old, entry.callIndex)
+	if env2 != nil {
+		info_old2, _, err := ctx.execute_consume(pid, env2, entry.p, idx)
+		if err != nil {
+			executionFailed(err)
+			return
+		}
+		baselineErr = checkBaselineDivergence(info_old, info_old2, entry.callIndex)
+	}
+	if baselineErr == nil && ctx.mustHit != nil && !ctx.hitsMustPCs(&info_old.Calls[entry.callIndex]) {
+		baselineErr = fmt.Errorf("call %v does not cover all -musthit PCs", entry.callIndex)
+	}
+	var sanitizerRep *sanitizerReport
+	if baselineErr == nil && ctx.sanitizer != "" {
+		sanitizerRep, baselineErr = sanitizerBaseline(env, ctx.klog, ctx.execOpts, ctx.reexec,
+			ctx.sanitizer, ctx.reportType, entry.p)
+		if sanitizerRep != nil {
+			infof(1, "program %v: preserving %v report %v", idx, ctx.sanitizer, sanitizerRep)
+		}
+	}
+	// fmt.Printf("%d,\n%v,\n%v,\n%s\n", idx, entry.file, entry.callIndex, entry.p)
+	if err := baselineErr; err != nil {
+		// Any candidate would match an empty baseline signal, so minimization would be meaningless.
+		log.Logf(0, "skipping program %v (%v): %v", idx, entry.file, err)
+		ctx.reportProgress(0, true)
+		ctx.summary.skip()
+	} else {
+		call_index_errno := info_old.Calls[entry.callIndex].Errno
+		p0 := entry.p
+		if prog.Influence_Learning_Enable {
+			// The entry is shared between repeats, so record hashes on a private copy.
+			p0 = entry.p.Clone()
+			p0.RecordCallsCovHash(ctx.callsCovHash(info_old))
+		}
+
+		closureSize := prog.ResourceClosureSize(entry.p, entry.callIndex)
+		infof(1, "program %v: %v calls, resource closure size %v", idx, len(entry.p.Calls), closureSize)
+		if err := entry.p.Target.CheckInfluence(entry.p); err != nil && !*flagNoInfluence {
+			log.Logf(0, "program %v (%v): %v, minimizing without influence", idx, entry.file, err)
+		}
+		// Candidates are counted by the minimizer (see prog.MinimizeStats), executions are counted
+		// separately since with -reexec a candidate may be executed several times.
+		var stats prog.MinimizeStats
+		executions := 0
+		start := time.Now()
+		opts := newMinimizeOptions(ctx.keepCalls, ctx.ignoreInfluencers, ctx.shutdown)
+		// Every pass must preserve the same call, the call index may change between passes.
+		opts.CallName = entry.p.Calls[entry.callIndex].Meta.Name
+		if *flagUseHints {
+			opts.CompValues = compValues(info_old)
+		}
+		opts.DetectFlaky = *flagDetectFlaky
+		if *flagTraceBFS {
+			opts.OnRetain = func(p1 *prog.Prog, path []int) {
+				log.Logf(0, "program %v: keeping call %v: %v", idx, path[0], retentionPath(p1, path))
+			}
+		}
+		countExec := func(p1 *prog.Prog, minimize_type_flag int, elapsed time.Duration) {
+			ctx.addExecTime(p1, elapsed)
+			executions++
+		}
+		orig := &execution{p: entry.p, callIndex: entry.callIndex, info: info_old, output: output_old}
+		execPred := func(p1 *prog.Prog, call1 int, minimize_type_flag int) bool {
+			for i := 0; i < ctx.reexec; i++ {
+				execStart := time.Now()
+				output, info, _, _ := env.Exec(ctx.execOpts, p1)
+				countExec(p1, minimize_type_flag, time.Since(execStart))
+
+				if !reexecutionSuccess(info) {
+					// The call was not executed or failed.
+					continue
+				}
+				if prog.Influence_Learning_Enable && p1.Influence_Hash_NeedUpdate {
+					p1.RecordCallsCovHash(ctx.callsCovHash(info))
+				}
+				if ctx.mustHit != nil {
+					if ctx.hitsMustPCs(&info.Calls[call1]) &&
+						(!ctx.matchErrno || info.Calls[call1].Errno == call_index_errno) {
+						return true
 					}
+				} else if ctx.predicate(orig, &execution{p: p1, callIndex: call1, info: info, output: output}) {
+					return true
+				}
+			}
+			return false
+		}
+		if env2 != nil {
+			execPred = differentialPred(env, env2, ctx.execOpts, ctx.reexec, countExec)
+		}
+		if sanitizerRep != nil {
+			execPred = sanitizerPred(env, ctx.klog, ctx.execOpts, ctx.reexec, ctx.sanitizer, sanitizerRep, countExec)
+		}
+		// Verification of the result is not subject to -maxexec.
+		verifyPred := execPred
+		capped := false
+		if *flagMaxExec != 0 {
+			limitedPred := execPred
+			execPred = func(p1 *prog.Prog, call1 int, minimize_type_flag int) bool {
+				// Rejecting all remaining candidates keeps the program reduced so far.
+				if executions >= *flagMaxExec {
+					capped = true
 					return false
-				})
+				}
+				return limitedPred(p1, call1, minimize_type_flag)
+			}
+		}
+		pred := execPred
+		if ctx.verdicts != nil {
+			pred = func(p1 *prog.Prog, call1 int, minimize_type_flag int) bool {
+				ok := execPred(p1, call1, minimize_type_flag)
+				ctx.verdicts.record(idx, p1, minimize_type_flag, ok)
+				return ok
+			}
+		}
+		p1, callIndex1, passes, err := minimizePasses(p0, entry.callIndex, ctx.passes,
+			func(p *prog.Prog, callIndex int) (*prog.Prog, int, error) {
+				// A sanitizer report is a crash, see prog.Minimize.
+				p1, callIndex1, passStats, err := prog.MinimizeWithStats(p, callIndex, sanitizerRep != nil,
+					pred, opts)
+				stats.Add(passStats)
+				return p1, callIndex1, err
+			})
+		if err != nil {
+			ctx.minimizationFailed(idx, entry.file, err)
+			return
+		}
+		elapsed := time.Since(start)
+		interrupted := ctx.interrupted()
+		if interrupted {
+			infof(0, "program %v: minimization interrupted, saving the partial result", idx)
+		}
+		// An interrupted program is not recorded as done, so a resumed run minimizes it again.
+		// parseOutPath treats any program index in -outpath as done, so the marker and the stats
+		// of an interrupted program are not written there either (-csvout still has its partial result).
+		if !interrupted {
+			ctx.markMinimized(idx)
+			ctx.appendOut(idx, fmt.Sprintf("%v\n", idx)) //mark
+		}
+		if capped {
+			infof(0, "program %v: reached %v executions, saving the partial result", idx, *flagMaxExec)
+		}
+		infof(1, "program %v: minimized in %v passes", idx, passes)
+		if stats.Flaky != 0 {
+			log.Logf(0, "program %v (%v): flaky predicate, %v candidates got both true and false verdicts",
+				idx, entry.file, stats.Flaky)
+		}
+		nondeterministic := false
+		if *flagForceSync && !interrupted {
+			if p2 := forceSync(p1); p2 != nil {
+				// The synchronous program is saved regardless, so that the output never has these props.
+				nondeterministic = !verifyPred(p2, callIndex1, 3)
+				if nondeterministic {
+					log.Logf(0, "program %v (%v): requires nondeterminism, the synchronous program "+
+						"doesn't reproduce the result", idx, entry.file)
+				}
+				p1 = p2
+			}
+		}
+		verifyPassed := 0
+		if *flagVerifyRuns != 0 && !interrupted {
+			verifyPassed = verifyMinimized(p1, callIndex1, *flagVerifyRuns, verifyPred)
+			if unstableVerification(verifyPassed, *flagVerifyRuns) {
+				log.Logf(0, "program %v (%v): unstable, the minimized program reproduced in %v of %v runs",
+					idx, entry.file, verifyPassed, *flagVerifyRuns)
+			}
+		}
+		ctx.reportProgress(elapsed, false)
+		if *flagMinOut != "" {
+			if err := writeMinimized(*flagMinOut, idx, callIndex1, p1); err != nil {
+				log.Logf(0, "failed to save minimized program %v: %v", idx, err)
+			}
+		}
+		if ctx.cOpts != nil {
+			if err := writeCSource(*flagCOut, idx, callIndex1, p1, *ctx.cOpts); err != nil {
+				log.Logf(0, "failed to write C reproducer for program %v: %v", idx, err)
+			}
+		}
 
-			// save minimize_count
-			if *flagOutPath != "" {
-				out_content := fmt.Sprintf("current idx:idx\n%v\n%v,%v,%v\n", idx, minimize_total_count, minimize_call_count, minimize_arg_count)
-				AppendToFile(*flagOutPath, out_content)
+		// save minimize_count
+		if !interrupted {
+			ctx.appendOut(idx, fmt.Sprintf("current idx:idx\n%v\n%v,%v,%v\n", idx, stats.Total, stats.Calls, stats.Args))
+		}
+		res := &minimizeResult{
+			idx:              idx,
+			file:             entry.file,
+			origCalls:        len(entry.p.Calls),
+			finalCalls:       len(p1.Calls),
+			totalExec:        stats.Total,
+			callExec:         stats.Calls,
+			argExec:          stats.Args,
+			influenceUpdates: stats.InfluenceUpdates,
+			elapsed:          elapsed,
+			validation:       ctx.validation[idx%len(ctx.progs)],
+			closureSize:      closureSize,
+			passes:           passes,
+			interrupted:      interrupted,
+			capped:           capped,
+			unstableBaseline: unstableBaseline,
+			verifyPassed:     verifyPassed,
+			nondeterministic: nondeterministic,
+		}
+		if *flagVerifyRuns != 0 && !interrupted {
+			res.verifyRuns = *flagVerifyRuns
+		}
+		ctx.splitStats.add(res)
+		ctx.fileStats.add(res)
+		ctx.summary.add(res)
+		if !interrupted {
+			ctx.attributeDuplicates(idx)
+		}
+		if ctx.checkpoint != nil && !interrupted {
+			if err := ctx.checkpoint.markDone(idx); err != nil {
+				log.Logf(0, "failed to record program %v in the checkpoint: %v", idx, err)
+			}
+		}
+		if ctx.csvOut != nil {
+			if err := ctx.csvOut.write(res); err != nil {
+				log.Logf(0, "failed to write csv row for program %v: %v", idx, err)
 			}
 		}
+	}
+}
+
+// minimizationFailed logs and skips program idx whose minimization returned an error.
+// The program is not recorded as minimized, so a resumed run tries it again.
+func (ctx *Context) minimizationFailed(idx int, file string, err error) {
+	log.Logf(0, "failed to minimize program %v (%v): %v", idx, file, err)
+	ctx.reportProgress(0, true)
+	ctx.summary.fail()
+}
+
+// forceSync returns a copy of p with async, rerun and fault injection props of all calls reset,
+// or nil if no call has any of them.
+func forceSync(p *prog.Prog) *prog.Prog {
+	var p1 *prog.Prog
+	for i, c := range p.Calls {
+		if c.Props == (prog.CallProps{}) {
+			continue
+		}
+		if p1 == nil {
+			p1 = p.Clone()
+		}
+		p1.Calls[i].Props = prog.CallProps{}
+	}
+	return p1
+}
+
+// verifyMinimized executes the minimized program runs times and returns the number of runs
+// where pred holds, i.e. the preserved call behaves as in the original program.
+func verifyMinimized(p *prog.Prog, callIndex, runs int, pred func(*prog.Prog, int, int) bool) int {
+	passed := 0
+	for i := 0; i < runs; i++ {
+		if pred(p, callIndex, 0) {
+			passed++
+		}
+	}
+	return passed
+}
+
+// unstableVerification returns true if the minimized program failed the majority of verification runs.
+func unstableVerification(passed, runs int) bool {
+	return runs != 0 && 2*(runs-passed) > runs
+}
+
+// retentionPath formats a path of prog.MinimizeOptions.OnRetain as a sequence of syscall names.
+func retentionPath(p *prog.Prog, path []int) string {
+	names := make([]string, len(path))
+	for i, idx := range path {
+		names[i] = p.Calls[idx].Meta.Name
+	}
+	return strings.Join(names, " -> ")
+}
 
+// newMinimizeOptions returns minimization options configured by flags.
+func newMinimizeOptions(keepCalls, ignoreInfluencers map[string]bool, stop <-chan struct{}) prog.MinimizeOptions {
+	return prog.MinimizeOptions{
+		MaxRemovalsPerPhase: *flagMaxRemovalsPerPhase,
+		DisableCalls:        *flagDisableCalls,
+		NoInfluence:         *flagNoInfluence,
+		KeepCalls:           keepCalls,
+		IgnoreInfluencers:   ignoreInfluencers,
+		ArgsOnly:            *flagArgsOnly,
+		MaxCalls:            *flagMaxCalls,
+		Stop:                stop,
+		NoPostRemove:        *flagNoPostRemove,
+		Stable:              *flagStableMin,
+		ArgsFocus:           *flagArgsFocus,
+		FreshResources:      *flagFreshResources,
 	}
 }
 
-func (ctx *Context) execute_consume(pid int, env *ipc.Env, p *prog.Prog, progIndex int) *ipc.ProgInfo {
+// execute_consume executes the original program. Failed executions are retried with exponential backoff,
+// an error is returned if the executor keeps failing.
+func (ctx *Context) execute_consume(pid int, env *ipc.Env, p *prog.Prog, progIndex int) (
+	*ipc.ProgInfo, []byte, error) {
 	// Limit concurrency window.
 	ticket := ctx.gate.Enter()
 	defer ctx.gate.Leave(ticket)
@@ -311,14 +1213,20 @@ func (ctx *Context) execute_consume(pid int, env *ipc.Env, p *prog.Prog, progInd
 	}
 	// This mimics the syz-fuzzer logic. This is important for reproduction.
 	for try := 0; ; try++ {
+		start := time.Now()
 		output, info, hanged, err := env.Exec(callOpts, p)
+		ctx.addExecTime(p, time.Since(start))
 		if err != nil && err != prog.ErrExecBufferTooSmall {
-			if try > 10 {
-				log.Fatalf("executor failed %v times: %v\n%s", try, err, output)
+			if try >= execRetries {
+				return nil, nil, fmt.Errorf("executor failed %v times: %w\n%s", try+1, err, output)
 			}
 			// Don't print err/output in this case as it may contain "SYZFAIL" and we want to fail yet.
 			log.Logf(1, "executor failed, retrying")
-			time.Sleep(time.Second)
+			select {
+			case <-time.After(execRetryDelay(try)):
+			case <-ctx.shutdown:
+				return nil, nil, fmt.Errorf("interrupted after %v failed executions: %w", try+1, err)
+			}
 			continue
 		}
 		if ctx.config.Flags&ipc.FlagDebug != 0 || err != nil {
@@ -331,12 +1239,60 @@ func (ctx *Context) execute_consume(pid int, env *ipc.Env, p *prog.Prog, progInd
 			}
 			if *flagCoverFile != "" {
 				covFile := fmt.Sprintf("%s_prog%d", *flagCoverFile, progIndex)
-				ctx.dumpCoverage(covFile, info)
+				ctx.dumpCoverage(covFile, p, info)
 			}
 		} else {
-			log.Logf(1, "RESULT: no calls executed")
+			infof(1, "RESULT: no calls executed")
 		}
-		return info
+		return info, output, nil
+	}
+}
+
+func (ctx *Context) interrupted() bool {
+	select {
+	case <-ctx.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ctx *Context) addExecTime(p *prog.Prog, elapsed time.Duration) {
+	if ctx.times != nil {
+		ctx.times.add(p, elapsed)
+	}
+}
+
+// attributeDuplicates marks duplicates of the minimized program idx as minimized as well.
+// They are listed in -outpath right after the results of idx.
+func (ctx *Context) attributeDuplicates(idx int) {
+	base := idx - idx%len(ctx.progs)
+	for _, dup := range ctx.duplicates[idx%len(ctx.progs)] {
+		dupIdx := base + dup
+		ctx.markMinimized(dupIdx)
+		ctx.appendOut(idx, fmt.Sprintf("%v\nduplicate of idx %v\n", dupIdx, idx))
+		if ctx.checkpoint != nil {
+			if err := ctx.checkpoint.markDone(dupIdx); err != nil {
+				log.Logf(0, "failed to record program %v in the checkpoint: %v", dupIdx, err)
+			}
+		}
+	}
+}
+
+// recordFailure skips program idx that the executor failed on,
+// the whole run is aborted only if too many programs fail.
+func (ctx *Context) recordFailure(idx int, file string, failure error) {
+	log.Logf(0, "skipping program %v (%v): %v", idx, file, failure)
+	ctx.reportProgress(0, true)
+	ctx.summary.fail()
+	abort, err := ctx.failures.record(idx, file, failure)
+	if err != nil {
+		log.Logf(0, "failed to write to -failout file: %v", err)
+	}
+	if abort {
+		// Workers stop as on an interrupt, so that main closes the output files before exiting.
+		log.Logf(0, "more than %v programs failed to execute, stopping", ctx.failures.max)
+		ctx.stop()
 	}
 }
 
@@ -371,15 +1327,24 @@ func (ctx *Context) execute(pid int, env *ipc.Env, p *prog.Prog, progIndex int)
 			}
 			if *flagCoverFile != "" {
 				covFile := fmt.Sprintf("%s_prog%d", *flagCoverFile, progIndex)
-				ctx.dumpCoverage(covFile, info)
+				ctx.dumpCoverage(covFile, p, info)
 			}
 		} else {
-			log.Logf(1, "RESULT: no calls executed")
+			infof(1, "RESULT: no calls executed")
 		}
 		break
 	}
 }
 
+// infof logs an informational message that is suppressed by -quiet.
+// Warnings and errors are logged with log.Logf, so that -quiet never hides them.
+func infof(v int, msg string, args ...interface{}) {
+	if *flagQuiet {
+		return
+	}
+	log.Logf(v, msg, args...)
+}
+
 func (ctx *Context) logProgram(pid int, p *prog.Prog, callOpts *ipc.ExecOpts) {
 	data := p.Serialize()
 	ctx.logMu.Lock()
@@ -402,11 +1367,25 @@ func (ctx *Context) printCallResults(info *ipc.ProgInfo) {
 		if inf.Flags&ipc.CallFaultInjected != 0 {
 			flags += " faulted"
 		}
-		log.Logf(1, "CALL %v: signal %v, coverage %v errno %v%v",
+		infof(1, "CALL %v: signal %v, coverage %v errno %v%v",
 			i, len(inf.Signal), len(inf.Cover), inf.Errno, flags)
 	}
 }
 
+// compValues returns all operands of comparisons collected while executing a program with -hints.
+func compValues(info *ipc.ProgInfo) map[uint64]bool {
+	values := make(map[uint64]bool)
+	for _, call := range info.Calls {
+		for v, args := range call.Comps {
+			values[v] = true
+			for arg := range args {
+				values[arg] = true
+			}
+		}
+	}
+	return values
+}
+
 func (ctx *Context) printHints(p *prog.Prog, info *ipc.ProgInfo) {
 	ncomps, ncandidates := 0, 0
 	for i := range p.Calls {
@@ -431,120 +1410,652 @@ func (ctx *Context) printHints(p *prog.Prog, info *ipc.ProgInfo) {
 			}
 		})
 	}
-	log.Logf(0, "ncomps=%v ncandidates=%v", ncomps, ncandidates)
+	infof(0, "ncomps=%v ncandidates=%v", ncomps, ncandidates)
 }
 
 func getKernelUpperBase(target *targets.Target) uint32 {
 	defaultRet := uint32(0xffffffff)
 	if target.OS == targets.Linux {
-		// Read the first 8 bytes from /proc/kallsyms.
 		f, err := os.Open("/proc/kallsyms")
 		if err != nil {
 			log.Logf(1, "could not get kernel fixup address: %v", err)
 			return defaultRet
 		}
 		defer f.Close()
-		data := make([]byte, 8)
-		_, err = f.ReadAt(data, 0)
-		if err != nil {
-			log.Logf(1, "could not get kernel fixup address: %v", err)
-			return defaultRet
-		}
-		value, err := strconv.ParseUint(string(data), 16, 32)
+		upperBase, err := parseKernelUpperBase(f)
 		if err != nil {
 			log.Logf(1, "could not get kernel fixup address: %v", err)
 			return defaultRet
 		}
-		return uint32(value)
+		return upperBase
 	}
 	return defaultRet
 }
 
+// parseKernelUpperBase returns the upper 32 bits of the address on the first line of /proc/kallsyms
+// (e.g. "ffffffff81000000 T _text"). Leading whitespace and addresses shorter than 16 digits are accepted.
+// Zero addresses, which kallsyms shows to unprivileged users, are an error.
+func parseKernelUpperBase(r io.Reader) (uint32, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("kallsyms is empty")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("no address on the first kallsyms line")
+	}
+	addr, err := strconv.ParseUint(fields[0], 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad kallsyms address: %w", err)
+	}
+	if addr == 0 {
+		return 0, fmt.Errorf("kallsyms addresses are hidden")
+	}
+	return uint32(addr >> 32), nil
+}
+
 func (ctx *Context) dumpCallCoverage(coverFile string, info *ipc.CallInfo) {
 	if len(info.Cover) == 0 {
 		return
 	}
-	buf := new(bytes.Buffer)
-	for _, pc := range info.Cover {
-		fmt.Fprintf(buf, "0x%x\n", backend.PreviousInstructionPC(ctx.target, cover.RestorePC(pc, ctx.upperBase)))
-	}
-	err := osutil.WriteFile(coverFile, buf.Bytes())
+	err := osutil.WriteFile(coverFile, formatCover(ctx.coverPCs(info), ctx.coverFormat, ctx.target.PtrSize))
 	if err != nil {
 		log.Fatalf("failed to write coverage file: %v", err)
 	}
 }
 
-func (ctx *Context) dumpCoverage(coverFile string, info *ipc.ProgInfo) {
+// coverPCs restores full kernel PCs of the call coverage.
+func (ctx *Context) coverPCs(info *ipc.CallInfo) []uint64 {
+	pcs := make([]uint64, len(info.Cover))
+	for i, pc := range info.Cover {
+		pcs[i] = backend.PreviousInstructionPC(ctx.target, cover.RestorePC(pc, ctx.upperBase))
+	}
+	return pcs
+}
+
+// formatCover serializes PCs either as text (one 0x-prefixed PC per line)
+// or as raw little-endian binary PCs of the target pointer size.
+func formatCover(pcs []uint64, format string, ptrSize uint64) []byte {
+	buf := new(bytes.Buffer)
+	for _, pc := range pcs {
+		switch {
+		case format == coverFormatText:
+			fmt.Fprintf(buf, "0x%x\n", pc)
+		case ptrSize == 4:
+			binary.Write(buf, binary.LittleEndian, uint32(pc))
+		default:
+			binary.Write(buf, binary.LittleEndian, pc)
+		}
+	}
+	return buf.Bytes()
+}
+
+// dumpCallSignal writes raw signal values of the call, e.g. to build a -covdenylist.
+func (ctx *Context) dumpCallSignal(signalFile string, info *ipc.CallInfo) {
+	if len(info.Signal) == 0 {
+		return
+	}
+	buf := new(bytes.Buffer)
+	for _, sig := range info.Signal {
+		fmt.Fprintf(buf, "0x%x\n", sig)
+	}
+	if err := osutil.WriteFile(signalFile, buf.Bytes()); err != nil {
+		log.Fatalf("failed to write signal file: %v", err)
+	}
+}
+
+func (ctx *Context) dumpCoverage(coverFile string, p *prog.Prog, info *ipc.ProgInfo) {
 	for i, inf := range info.Calls {
-		log.Logf(0, "call #%v: signal %v, coverage %v", i, len(inf.Signal), len(inf.Cover))
+		infof(0, "call #%v: signal %v, coverage %v", i, len(inf.Signal), len(inf.Cover))
 		ctx.dumpCallCoverage(fmt.Sprintf("%v.%v", coverFile, i), &inf)
+		ctx.dumpCallSignal(fmt.Sprintf("%v.%v.signal", coverFile, i), &inf)
 	}
-	log.Logf(0, "extra: signal %v, coverage %v", len(info.Extra.Signal), len(info.Extra.Cover))
+	infof(0, "extra: signal %v, coverage %v", len(info.Extra.Signal), len(info.Extra.Cover))
 	ctx.dumpCallCoverage(fmt.Sprintf("%v.extra", coverFile), &info.Extra)
+	if ctx.covFolded {
+		ctx.dumpFoldedCoverage(coverFile+".folded", p, info)
+	}
+}
+
+// dumpFoldedCoverage writes coverage of all calls of the program as folded stacks rooted at call names,
+// e.g. for flamegraph.pl. Functions are symbolized with -kernelobj, otherwise raw PCs are used.
+func (ctx *Context) dumpFoldedCoverage(foldedFile string, p *prog.Prog, info *ipc.ProgInfo) {
+	buf := new(bytes.Buffer)
+	for i := range info.Calls {
+		if i < len(p.Calls) {
+			foldCover(buf, p.Calls[i].Meta.Name, ctx.coverPCs(&info.Calls[i]), ctx.symbolizer)
+		}
+	}
+	foldCover(buf, "extra", ctx.coverPCs(&info.Extra), ctx.symbolizer)
+	if err := osutil.WriteFile(foldedFile, buf.Bytes()); err != nil {
+		log.Fatalf("failed to write folded coverage file: %v", err)
+	}
+}
+
+func dumpSyscalls(filename string) {
+	target, err := prog.GetTarget(*flagOS, *flagArch)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := target.DumpSyscallIndex(buf); err != nil {
+		log.Fatalf("failed to dump syscalls: %v", err)
+	}
+	if err := osutil.WriteFile(filename, buf.Bytes()); err != nil {
+		log.Fatalf("failed to write syscalls: %v", err)
+	}
+}
+
+// applyInfluenceProportion randomly drops edges of the target influence matrix
+// (or of its sparse variant) to keep only proportion percent of them.
+func applyInfluenceProportion(target *prog.Target, proportion int, rnd *rand.Rand) {
+	if proportion != 0 && proportion != 100 {
+		var onesCoords []struct{ row, col int }
+		for i := range target.InfluenceMatrix {
+			for j := range target.InfluenceMatrix[i] {
+				if target.InfluenceMatrix[i][j] == 1 {
+					onesCoords = append(onesCoords, struct{ row, col int }{i, j})
+				}
+			}
+		}
+		for _, edge := range sortedSparseInfluence(target.SparseInfluence) {
+			onesCoords = append(onesCoords, struct{ row, col int }{edge.Src, edge.Dest})
+		}
+		numToZero := len(onesCoords) / 100 * (100 - proportion)
+		if numToZero == 0 {
+			return
+		}
+		for _, idx := range rnd.Perm(len(onesCoords))[:numToZero] {
+			coord := onesCoords[idx]
+			if target.SparseInfluence != nil {
+				delete(target.SparseInfluence, prog.InfluenceEdge{Src: coord.row, Dest: coord.col})
+			} else {
+				target.InfluenceMatrix[coord.row][coord.col] = 0
+			}
+		}
+	}
+
+	infof(1, "influence matrix: %v edges after applying proportion %v%%",
+		influenceEdges(target), proportion)
+}
+
+// sortedSparseInfluence returns the sparse influence edges in a deterministic order,
+// so that a fixed -seed drops the same edges.
+func sortedSparseInfluence(sparse map[prog.InfluenceEdge]bool) []prog.InfluenceEdge {
+	var edges []prog.InfluenceEdge
+	for edge, ok := range sparse {
+		if ok {
+			edges = append(edges, edge)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Src != edges[j].Src {
+			return edges[i].Src < edges[j].Src
+		}
+		return edges[i].Dest < edges[j].Dest
+	})
+	return edges
+}
+
+func influenceEdges(target *prog.Target) int {
+	count := len(sortedSparseInfluence(target.SparseInfluence))
+	for i := range target.InfluenceMatrix {
+		for j := range target.InfluenceMatrix[i] {
+			if target.InfluenceMatrix[i][j] == 1 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// printTopInfluencers prints n syscalls with the highest influence out-degree.
+func printTopInfluencers(w io.Writer, target *prog.Target, n int) {
+	inDeg, outDeg := target.InfluenceDegrees()
+	ids := make([]int, len(outDeg))
+	for id := range ids {
+		ids[id] = id
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return outDeg[ids[i]] > outDeg[ids[j]]
+	})
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	for _, id := range ids {
+		fmt.Fprintf(w, "%v: %v outgoing, %v incoming influence edges\n",
+			target.Syscalls[id].Name, outDeg[id], inDeg[id])
+	}
+}
+
+// usedSyscalls returns IDs of syscalls used by the programs.
+func usedSyscalls(progs []*programEntry) map[int]bool {
+	calls := make(map[int]bool)
+	for _, entry := range progs {
+		for _, c := range entry.p.Calls {
+			calls[c.Meta.ID] = true
+		}
+	}
+	return calls
+}
+
+func (ctx *Context) isMinimized(idx int) bool {
+	ctx.posMu.Lock()
+	defer ctx.posMu.Unlock()
+	return ctx.minimized[idx]
+}
+
+func (ctx *Context) markMinimized(idx int) {
+	ctx.posMu.Lock()
+	defer ctx.posMu.Unlock()
+	ctx.minimized[idx] = true
 }
 
 func (ctx *Context) getProgramIndex() int {
 	ctx.posMu.Lock()
 	idx := ctx.pos
 	ctx.pos++
-	if idx%len(ctx.progs) == 0 && time.Since(ctx.lastPrint) > 5*time.Second {
-		log.Logf(0, "executed programs: %v", idx)
+	if len(ctx.progs) != 0 && idx%len(ctx.progs) == 0 && time.Since(ctx.lastPrint) > 5*time.Second {
+		infof(0, "executed programs: %v", idx)
 		ctx.lastPrint = time.Now()
 	}
 	ctx.posMu.Unlock()
 	return idx
 }
 
-func loadPrograms_comsume(target *prog.Target) []*prog.Prog {
-	var progs []*prog.Prog
-	for _, fn := range file_path_ary {
-		if corpus, err := db.Open(fn, false); err == nil {
-			for _, rec := range corpus.Records {
-				p, err := target.Deserialize(rec.Val, prog.NonStrict)
-				if err != nil {
-					continue
-				}
-				progs = append(progs, p)
-			}
-			continue
+// programEntry is a loaded program along with its origin.
+type programEntry struct {
+	p         *prog.Prog
+	file      string
+	callIndex int // index of the call to preserve during minimization
+}
+
+func loadPrograms_comsume(target *prog.Target) []*programEntry {
+	progs, err := loadProgramEntries(target, file_path_ary, call_index_ary)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	infof(0, "parsed %v programs", len(progs))
+	return progs
+}
+
+// loadProgramEntries loads programs from files, callIndices hold the call index of each file
+// (see parseCallIndex) unless a program specifies it with an annotation.
+// It fails if any of the files can't be read.
+func loadProgramEntries(target *prog.Target, files []string, callIndices []int) ([]*programEntry, error) {
+	var progs []*programEntry
+	for i, fn := range files {
+		callIndex := -1
+		if i < len(callIndices) {
+			callIndex = callIndices[i]
 		}
-		data, err := os.ReadFile(fn)
+		fileProgs, err := loadProgramFileReport(target, fn, *flagFormat, *flagParseErrors)
 		if err != nil {
-			log.Fatalf("failed to read log file: %v", err)
+			return nil, err
 		}
-		for _, entry := range target.ParseLog(data) {
-			progs = append(progs, entry.P)
+		for _, p := range fileProgs {
+			entry := &programEntry{
+				p:         p,
+				file:      fn,
+				callIndex: callIndex,
+			}
+			if idx, ok, err := annotatedCallIndex(p); err != nil {
+				log.Logf(0, "%v: %v", fn, err)
+				entry.callIndex = -1
+			} else if ok {
+				entry.callIndex = idx
+			}
+			progs = append(progs, entry)
 		}
 	}
-	log.Logf(0, "parsed %v programs", len(progs))
-	return progs
+	return progs, nil
 }
 
 func loadPrograms(target *prog.Target, files []string) []*prog.Prog {
 	var progs []*prog.Prog
 	for _, fn := range files {
-		if corpus, err := db.Open(fn, false); err == nil {
-			for _, rec := range corpus.Records {
+		fileProgs, errs, err := loadProgramFile(target, fn, *flagFormat)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, err := range errs {
+			log.Logf(0, "%v", err)
+		}
+		progs = append(progs, fileProgs...)
+	}
+	infof(0, "parsed %v programs", len(progs))
+	return progs
+}
+
+// loadProgramFileReport loads programs from fn skipping records that fail to parse.
+// It logs the number of such records and the first maxErrors errors.
+func loadProgramFileReport(target *prog.Target, fn, format string, maxErrors int) ([]*prog.Prog, error) {
+	progs, errs, err := loadProgramFile(target, fn, format)
+	if err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		if i >= maxErrors {
+			break
+		}
+		log.Logf(0, "%v", err)
+	}
+	if len(errs) != 0 {
+		log.Logf(0, "%v: %v of %v records failed to parse", fn, len(errs), len(errs)+len(progs))
+	}
+	return progs, nil
+}
+
+// parseOutPath returns indices of programs marked as minimized in the -outpath file of a previous run.
+// Lines that don't parse as a program index are skipped with a warning.
+func parseOutPath(r io.Reader) (map[int]bool, error) {
+	done := make(map[int]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		// Skip the metadata header, the "current idx:idx" headers and the execution count lines.
+		if strings.HasPrefix(text, metadataPrefix) || strings.Contains(text, "idx") || strings.Contains(text, ",") {
+			continue
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		index, err := strconv.Atoi(text)
+		if err != nil || index < 0 {
+			log.Logf(0, "-outpath line %v: ignoring bad program index %q", line, text)
+			continue
+		}
+		infof(2, "program %v is already minimized", index)
+		done[index] = true
+	}
+	return done, scanner.Err()
+}
+
+// parseCallIndex extracts the index of the call to preserve from a program file name
+// of the form prefix_N[_suffix]. It returns -1 and an error if the name does not follow it.
+func parseCallIndex(name string) (int, error) {
+	parts := strings.Split(name, "_")
+	if len(parts) < 2 {
+		return -1, fmt.Errorf("no call index in file name %q", name)
+	}
+	callIndex, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return -1, fmt.Errorf("bad call index in file name %q: %w", name, err)
+	}
+	return callIndex, nil
+}
+
+// callAnnotation is a comment in a serialized program that specifies the index of the call
+// to preserve, e.g. "# minimize_call: 2". It takes precedence over the file name convention.
+// The index may be followed by the expected syscall name, e.g. "# minimize_call: 2 pipe2",
+// to catch annotations that went stale after the program was edited.
+const callAnnotation = "minimize_call:"
+
+// annotatedCallIndex returns the call index specified by a callAnnotation comment of p.
+// ok is false if p has no such comment.
+func annotatedCallIndex(p *prog.Prog) (idx int, ok bool, err error) {
+	comments := append([]string{}, p.Comments...)
+	for _, c := range p.Calls {
+		comments = append(comments, c.Comment)
+	}
+	for _, comment := range comments {
+		if !strings.HasPrefix(comment, callAnnotation) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(comment, callAnnotation))
+		if len(fields) == 0 || len(fields) > 2 {
+			return -1, true, fmt.Errorf("bad call annotation %q", comment)
+		}
+		idx, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return -1, true, fmt.Errorf("bad call annotation %q: %w", comment, err)
+		}
+		if idx < 0 || idx >= len(p.Calls) {
+			return -1, true, fmt.Errorf("annotated call index %v is out of range [0, %v)", idx, len(p.Calls))
+		}
+		if len(fields) == 2 && p.Calls[idx].Meta.Name != fields[1] {
+			return -1, true, fmt.Errorf("annotated call %v is %v, expected %v",
+				idx, p.Calls[idx].Meta.Name, fields[1])
+		}
+		return idx, true, nil
+	}
+	return -1, false, nil
+}
+
+// writeMinimized saves minimized program p with the original index idx to dir.
+// The preserved call index is encoded in the file name the same way as for input programs.
+func writeMinimized(dir string, idx, callIndex int, p *prog.Prog) error {
+	fn := filepath.Join(dir, fmt.Sprintf("prog%v_%v", idx, callIndex))
+	return osutil.WriteFile(fn, p.Serialize())
+}
+
+// defaultCSourceOpts returns the options syz-repro starts with for target, with the sandbox
+// used for execution, so that the C reproducer behaves like the minimized program did.
+func defaultCSourceOpts(target *prog.Target) csource.Options {
+	sandbox := "none"
+	if f := flag.Lookup("sandbox"); f != nil {
+		sandbox = f.Value.String()
+	}
+	return csource.DefaultOpts(&mgrconfig.Config{
+		Procs:   1,
+		Sandbox: sandbox,
+		Derived: mgrconfig.Derived{
+			TargetOS: target.OS,
+			Timeouts: targets.Get(target.OS, target.Arch).Timeouts(1),
+		},
+	})
+}
+
+// writeCSource saves a C reproducer of minimized program p to dir, named as with writeMinimized plus ".c".
+func writeCSource(dir string, idx, callIndex int, p *prog.Prog, opts csource.Options) error {
+	src, err := csource.Write(p, opts)
+	if err != nil {
+		return err
+	}
+	// Formatting needs clang-format, the source is usable without it.
+	if formatted, err := csource.Format(src); err == nil {
+		src = formatted
+	}
+	fn := filepath.Join(dir, fmt.Sprintf("prog%v_%v.c", idx, callIndex))
+	return osutil.WriteFile(fn, src)
+}
+
+// parseSyscallList parses a comma-separated list of syscall names (e.g. -keepcalls) into a set.
+func parseSyscallList(target *prog.Target, list string) (map[string]bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+	names := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if target.SyscallMap[name] == nil {
+			return nil, fmt.Errorf("unknown syscall %q", name)
+		}
+		names[name] = true
+	}
+	return names, nil
+}
+
+// memoryLimitedProcs returns the number of workers that fit into maxMem if each of them
+// takes workerMem, but not more than procs and at least one.
+func memoryLimitedProcs(procs, maxMem, workerMem int) int {
+	if n := maxMem / workerMem; n < procs {
+		procs = n
+	}
+	if procs < 1 {
+		procs = 1
+	}
+	return procs
+}
+
+// parseSubsystem parses a comma-separated list of syscall name prefixes (see -subsystem)
+// into a set of IDs of matching syscalls. Every prefix must match at least one syscall.
+func parseSubsystem(target *prog.Target, list string) (map[int]bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+	calls := make(map[int]bool)
+	for _, prefix := range strings.Split(list, ",") {
+		prefix = strings.TrimSpace(prefix)
+		matched := false
+		for _, c := range target.Syscalls {
+			if strings.HasPrefix(c.Name, prefix) {
+				calls[c.ID] = true
+				matched = true
+			}
+		}
+		if prefix == "" || !matched {
+			return nil, fmt.Errorf("no syscalls match prefix %q", prefix)
+		}
+	}
+	return calls, nil
+}
+
+// minimizePasses runs minimize on its own output until a pass does not change the program
+// or maxPasses passes are run. Returns the result and the number of passes run.
+func minimizePasses(p *prog.Prog, callIndex, maxPasses int,
+	minimize func(p *prog.Prog, callIndex int) (*prog.Prog, int, error)) (*prog.Prog, int, int, error) {
+	data := p.Serialize()
+	passes := 0
+	for passes < maxPasses {
+		p1, callIndex1, err := minimize(p, callIndex)
+		passes++
+		if err != nil {
+			return p, callIndex, passes, err
+		}
+		data1 := p1.Serialize()
+		changed := callIndex1 != callIndex || !bytes.Equal(data, data1)
+		p, callIndex, data = p1, callIndex1, data1
+		if !changed {
+			break
+		}
+	}
+	return p, callIndex, passes, nil
+}
+
+// validateCallIndices returns indices of programs whose call index
+// does not refer to a call of the program.
+func validateCallIndices(progs []*programEntry) map[int]bool {
+	invalid := make(map[int]bool)
+	for i, entry := range progs {
+		if entry.callIndex < 0 || entry.callIndex >= len(entry.p.Calls) {
+			log.Logf(0, "program %v from %v: call index %v is out of range [0, %v)",
+				i, entry.file, entry.callIndex, len(entry.p.Calls))
+			invalid[i] = true
+		}
+	}
+	return invalid
+}
+
+// Program file formats accepted by -format.
+const (
+	formatAuto = "auto" // corpus.db if the file is a valid corpus, log otherwise
+	formatDB   = "db"
+	formatLog  = "log"
+	formatSyz  = "syz" // a single serialized program
+)
+
+// LoadError describes a program that failed to deserialize while loading a program file.
+type LoadError struct {
+	File   string
+	Record string // corpus record key, empty for single-program files
+	Err    error
+}
+
+func (err *LoadError) Error() string {
+	if err.Record == "" {
+		return fmt.Sprintf("%v: %v", err.File, err.Err)
+	}
+	return fmt.Sprintf("%v: record %v: %v", err.File, err.Record, err.Err)
+}
+
+func (err *LoadError) Unwrap() error {
+	return err.Err
+}
+
+// loadProgramFile loads programs from a file of the given format, optionally gzip-compressed.
+// Programs that fail to deserialize are skipped and returned as load errors,
+// the error is returned if the file itself can't be read.
+func loadProgramFile(target *prog.Target, fn, format string) ([]*prog.Prog, []*LoadError, error) {
+	data, err := readCompressed(fn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %v: %w", fn, err)
+	}
+	if format == formatAuto || format == formatDB {
+		var corpus *db.DB
+		if data != nil {
+			corpus, err = db.Deserialize(data)
+		} else {
+			corpus, err = db.Open(fn, false)
+		}
+		if err == nil {
+			// Records are loaded in the order of their keys, since program indices must be the same
+			// in every run over the same corpus (see -checkpoint, -replayverdicts and -validationfraction).
+			keys := make([]string, 0, len(corpus.Records))
+			for key := range corpus.Records {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			var progs []*prog.Prog
+			var errs []*LoadError
+			for _, key := range keys {
+				rec := corpus.Records[key]
 				p, err := target.Deserialize(rec.Val, prog.NonStrict)
 				if err != nil {
-					fmt.Printf("%v\n", err)
+					errs = append(errs, &LoadError{File: fn, Record: key, Err: err})
 					continue
 				}
 				progs = append(progs, p)
 			}
-			fmt.Printf("%v\n", err)
-			continue
+			return progs, errs, nil
+		}
+		if format == formatDB {
+			return nil, nil, fmt.Errorf("failed to open corpus %v: %w", fn, err)
 		}
-		data, err := os.ReadFile(fn)
+	}
+	if data == nil {
+		data, err = os.ReadFile(fn)
 		if err != nil {
-			log.Fatalf("failed to read log file: %v", err)
+			return nil, nil, fmt.Errorf("failed to read log file: %w", err)
 		}
-		for _, entry := range target.ParseLog(data) {
-			progs = append(progs, entry.P)
+	}
+	if format == formatSyz {
+		p, err := target.Deserialize(data, prog.NonStrict)
+		if err != nil {
+			return nil, []*LoadError{{File: fn, Err: err}}, nil
 		}
+		return []*prog.Prog{p}, nil, nil
 	}
-	log.Logf(0, "parsed %v programs", len(progs))
-	return progs
+	var progs []*prog.Prog
+	for _, entry := range target.ParseLog(data) {
+		progs = append(progs, entry.P)
+	}
+	return progs, nil, nil
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// readCompressed returns decompressed contents of fn if it is gzip-compressed, and nil otherwise.
+func readCompressed(fn string) ([]byte, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if magic, err := r.Peek(len(gzipMagic)); err != nil || !bytes.Equal(magic, gzipMagic) {
+		return nil, nil
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
 }
 
 func createConfig(target *prog.Target, features *host.Features, featuresFlags csource.Features) (
@@ -556,7 +2067,7 @@ func createConfig(target *prog.Target, features *host.Features, featuresFlags cs
 	if config.Flags&ipc.FlagSignal != 0 {
 		execOpts.Flags |= ipc.FlagCollectCover
 	}
-	if *flagCoverFile != "" {
+	if *flagCoverFile != "" || *flagMustHit != "" {
 		config.Flags |= ipc.FlagSignal
 		execOpts.Flags |= ipc.FlagCollectCover
 		execOpts.Flags &^= ipc.FlagDedupCover
@@ -603,23 +2114,117 @@ func createConfig(target *prog.Target, features *host.Features, featuresFlags cs
 	return config, execOpts
 }
 
+// callsCovHash returns per-call coverage hashes used by dynamic influence learning.
+func (ctx *Context) callsCovHash(info *ipc.ProgInfo) []uint32 {
+	hashes := make([]uint32, len(info.Calls))
+	for i, call := range info.Calls {
+		hashes[i] = prog.SignalHash(filterSignal(call.Signal, ctx.covDenylist))
+	}
+	return hashes
+}
+
+// checkBaselineCall checks that the call to preserve executed in the original program
+// and produced signal to compare minimization candidates against.
+func checkBaselineCall(info *ipc.ProgInfo, callIndex int) error {
+	if info == nil {
+		return fmt.Errorf("no calls executed")
+	}
+	if callIndex < 0 || callIndex >= len(info.Calls) {
+		return fmt.Errorf("call %v is not executed (%v calls executed)", callIndex, len(info.Calls))
+	}
+	inf := &info.Calls[callIndex]
+	if inf.Flags&ipc.CallExecuted == 0 {
+		return fmt.Errorf("call %v is not executed", callIndex)
+	}
+	if len(inf.Signal) == 0 {
+		return fmt.Errorf("call %v produced no signal", callIndex)
+	}
+	return nil
+}
+
+// stableBaseline returns the execution whose result of call callIndex (signal hash and errno)
+// is the most frequent one among infos, the earliest one wins a tie. unstable is set if the call
+// didn't behave the same in all executions.
+func stableBaseline(infos []*ipc.ProgInfo, callIndex int) (best *ipc.ProgInfo, unstable bool) {
+	type callResult struct {
+		hash  uint32
+		errno int
+	}
+	counts := make(map[callResult]int)
+	first := make(map[callResult]*ipc.ProgInfo)
+	bestCount := 0
+	for _, info := range infos {
+		if !callExecuted(info, callIndex) {
+			unstable = true
+			continue
+		}
+		inf := &info.Calls[callIndex]
+		res := callResult{prog.SignalHash(inf.Signal), inf.Errno}
+		if first[res] == nil {
+			first[res] = info
+		}
+		counts[res]++
+		if counts[res] > bestCount {
+			best, bestCount = first[res], counts[res]
+		}
+	}
+	if best == nil {
+		return infos[0], unstable
+	}
+	return best, unstable || len(counts) > 1
+}
+
+// sameCallResult returns true if the call has the original coverage hash
+// and, if matchErrno is set, the original errno.
+func sameCallResult(inf *ipc.CallInfo, hash uint32, errno int, matchErrno bool) bool {
+	if prog.SignalHash(inf.Signal) != hash {
+		return false
+	}
+	return !matchErrno || inf.Errno == errno
+}
+
 func reexecutionSuccess(info *ipc.ProgInfo) bool {
 	if info == nil || len(info.Calls) == 0 {
 		return false
 	}
 	return true
 }
-func AppendToFile(filename string, text string) error {
-	// 打开文件以追加写入
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf("open file error-> %v\n", err)
-		return err
+
+// appendOut appends text of program idx to the -outpath file, if any.
+// With -ordered the text is held back until all preceding programs finish.
+func (ctx *Context) appendOut(idx int, text string) {
+	if ctx.out == nil {
+		return
 	}
+	if ctx.ordered != nil {
+		ctx.ordered.add(idx, text)
+		return
+	}
+	ctx.writeOut(text)
+}
 
-	defer file.Close()
+// finishOut is called when processing of program idx is finished.
+func (ctx *Context) finishOut(idx int) {
+	if ctx.out == nil || ctx.ordered == nil {
+		return
+	}
+	if text := ctx.ordered.finish(idx); text != "" {
+		ctx.writeOut(text)
+	}
+}
+
+// drainOut writes output held back by -ordered after all workers exit.
+func (ctx *Context) drainOut() {
+	if ctx.out == nil || ctx.ordered == nil {
+		return
+	}
+	if text := ctx.ordered.drain(); text != "" {
+		ctx.writeOut(text)
+	}
+}
 
-	// 写入文本到文件
-	_, err = file.WriteString(text)
-	return err
+func (ctx *Context) writeOut(text string) {
+	if err := ctx.out.append(text); err != nil {
+		log.Logf(0, "failed to write to -outpath file: %v", err)
+	}
 }
@@ -0,0 +1,1744 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/syzkaller/pkg/cover/backend"
+	"github.com/google/syzkaller/pkg/db"
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/prog"
+	_ "github.com/google/syzkaller/sys"
+)
+
+func TestSplitValidation(t *testing.T) {
+	tests := []struct {
+		n        int
+		fraction float64
+		want     int
+	}{
+		{0, 0.5, 0},
+		{10, 0, 0},
+		{10, 0.2, 2},
+		{10, 0.25, 3},
+		{7, 1, 7},
+		{100, 0.1, 10},
+	}
+	for _, test := range tests {
+		held := splitValidation(test.n, test.fraction, 1)
+		if len(held) != test.want {
+			t.Errorf("n=%v fraction=%v: got %v held out programs, want %v",
+				test.n, test.fraction, len(held), test.want)
+		}
+		for idx := range held {
+			if idx < 0 || idx >= test.n {
+				t.Errorf("n=%v fraction=%v: held out index %v is out of range", test.n, test.fraction, idx)
+			}
+		}
+	}
+}
+
+func TestSplitValidationDeterministic(t *testing.T) {
+	const seed = 42
+	held0 := splitValidation(1000, 0.3, seed)
+	held1 := splitValidation(1000, 0.3, seed)
+	if !reflect.DeepEqual(held0, held1) {
+		t.Fatalf("split differs for the same seed")
+	}
+	// The split must not depend on earlier use of the -seed random source, e.g. by
+	// applyInfluenceProportion, whose use depends on -influenceproportion and the number of edges.
+	rnd := rand.New(rand.NewSource(seed))
+	for _, edges := range []int{0, 10, 1000} {
+		rnd.Perm(edges)
+		if held := splitValidation(1000, 0.3, seed); !reflect.DeepEqual(held0, held) {
+			t.Fatalf("split depends on earlier use of the random source")
+		}
+	}
+	held2 := splitValidation(1000, 0.3, seed+1)
+	if reflect.DeepEqual(held0, held2) {
+		t.Fatalf("split does not depend on the seed")
+	}
+}
+
+func TestLoadProgramFileCompressed(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const text = "pipe2(&(0x7f0000000000), 0x0)\n"
+	dir := t.TempDir()
+	corpusFile := filepath.Join(dir, "corpus.db")
+	if err := db.Create(corpusFile, 0, []db.Record{{Val: []byte(text)}}); err != nil {
+		t.Fatal(err)
+	}
+	corpusData, err := os.ReadFile(corpusFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"log":        []byte(text),
+		"log.gz":     gzipData(t, []byte(text)),
+		"corpus.gz":  gzipData(t, corpusData),
+		"corpus.raw": corpusData,
+	}
+	for name, data := range files {
+		fn := filepath.Join(dir, name)
+		if err := os.WriteFile(fn, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		progs, errs, err := loadProgramFile(target, fn, formatAuto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, err := range errs {
+			t.Errorf("%v: %v", name, err)
+		}
+		if len(progs) != 1 || string(progs[0].Serialize()) != text {
+			t.Errorf("%v: loaded %v programs, want the original program", name, len(progs))
+		}
+	}
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseCallIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+		ok   bool
+	}{
+		{"prog_3", 3, true},
+		{"prog_0_min", 0, true},
+		{"prog", -1, false},
+		{"prog_x", -1, false},
+		{"_", -1, false},
+	}
+	for _, test := range tests {
+		got, err := parseCallIndex(test.name)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("%q: got %v/%v, want %v/%v", test.name, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestAnnotatedCallIndex(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const calls = "sched_yield()\npipe2(&(0x7f0000000000), 0x0)\n"
+	tests := []struct {
+		text string
+		want int
+		ok   bool
+		err  bool
+	}{
+		{calls, -1, false, false},
+		{"# minimize_call: 1\n" + calls, 1, true, false},
+		{"# https://syzkaller.appspot.com/bug?id=0\n# minimize_call: 0\n" + calls, 0, true, false},
+		{"sched_yield() # minimize_call: 1\npipe2(&(0x7f0000000000), 0x0)\n", 1, true, false},
+		{"# minimize_call: 2\n" + calls, -1, true, true},
+		{"# minimize_call: x\n" + calls, -1, true, true},
+		{"# another comment\n" + calls, -1, false, false},
+		{"# minimize_call: 1 pipe2\n" + calls, 1, true, false},
+		{"# minimize_call: 1 sched_yield\n" + calls, -1, true, true},
+		{"# minimize_call: 1 pipe2 x\n" + calls, -1, true, true},
+		{"# minimize_call:\n" + calls, -1, true, true},
+	}
+	for i, test := range tests {
+		p, err := target.Deserialize([]byte(test.text), prog.NonStrict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok, err := annotatedCallIndex(p)
+		if got != test.want || ok != test.ok || (err != nil) != test.err {
+			t.Errorf("#%v: got %v/%v/%v, want %v/%v/error %v", i, got, ok, err, test.want, test.ok, test.err)
+		}
+	}
+}
+
+func TestValidateCallIndices(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\npipe2(&(0x7f0000000000), 0x0)\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed, _ := parseCallIndex("prog")
+	var progs []*programEntry
+	for _, callIndex := range []int{1, 2, malformed, 0} {
+		progs = append(progs, &programEntry{p: p, callIndex: callIndex})
+	}
+	invalid := validateCallIndices(progs)
+	want := map[int]bool{1: true, 2: true}
+	if !reflect.DeepEqual(invalid, want) {
+		t.Fatalf("got invalid programs %v, want %v", invalid, want)
+	}
+}
+
+func TestLoadProgramsSourceFile(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"a_0": "sched_yield()\n",
+		"b_1": "sched_yield()\npipe2(&(0x7f0000000000), 0x0)\n",
+	}
+	defer func(paths []string, indices []int) {
+		file_path_ary, call_index_ary = paths, indices
+	}(file_path_ary, call_index_ary)
+	file_path_ary, call_index_ary = nil, nil
+	for _, name := range []string{"a_0", "b_1"} {
+		fn := filepath.Join(dir, name)
+		if err := os.WriteFile(fn, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		callIndex, err := parseCallIndex(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file_path_ary = append(file_path_ary, fn)
+		call_index_ary = append(call_index_ary, callIndex)
+	}
+	progs := loadPrograms_comsume(target)
+	if len(progs) != 2 {
+		t.Fatalf("loaded %v programs, want 2", len(progs))
+	}
+	var stats fileStats
+	for i, entry := range progs {
+		name := filepath.Base(entry.file)
+		if string(entry.p.Serialize()) != files[name] {
+			t.Errorf("program %v is attributed to %v:\n%s", i, name, entry.p.Serialize())
+		}
+		stats.add(&minimizeResult{
+			idx:        i,
+			file:       entry.file,
+			origCalls:  len(entry.p.Calls),
+			finalCalls: 1,
+		})
+	}
+	for _, entry := range progs {
+		totals := stats.files[entry.file]
+		if totals == nil || totals.programs != 1 || totals.origCalls != len(entry.p.Calls) {
+			t.Errorf("bad totals for %v: %+v", entry.file, totals)
+		}
+	}
+}
+
+func TestPendingPrograms(t *testing.T) {
+	minimized := map[int]bool{0: true, 4: true, 100: true}
+	invalid := map[int]bool{2: true}
+	if got := pendingPrograms(3, 2, minimized, invalid); got != 2 {
+		t.Errorf("got %v pending programs, want 2", got)
+	}
+	if got := pendingPrograms(3, 0, minimized, invalid); got != 0 {
+		t.Errorf("got %v pending programs for an infinite run, want 0", got)
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	pr := &progress{total: 10, procs: 2}
+	if eta := pr.eta(); eta != 0 {
+		t.Errorf("got eta %v before any program is done", eta)
+	}
+	pr.done, pr.failed, pr.busy = 3, 1, 3*time.Minute
+	if eta := pr.eta(); eta != 3*time.Minute {
+		t.Errorf("got eta %v, want %v", eta, 3*time.Minute)
+	}
+	const want = "minimized programs: 3/10 (40.0%, failed 1), eta 3m0s"
+	if got := pr.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteMinimized(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const text = "sched_yield()\npipe2(&(0x7f0000000000), 0x0)\n"
+	p, err := target.Deserialize([]byte(text), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := writeMinimized(dir, 42, 1, p); err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %v files, want 1", len(files))
+	}
+	if callIndex, err := parseCallIndex(files[0].Name()); err != nil || callIndex != 1 {
+		t.Fatalf("file %v: got call index %v/%v, want 1", files[0].Name(), callIndex, err)
+	}
+	progs, errs, err := loadProgramFile(target, filepath.Join(dir, files[0].Name()), formatAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range errs {
+		t.Error(err)
+	}
+	if len(progs) != 1 || string(progs[0].Serialize()) != text {
+		t.Fatalf("saved program does not match the original")
+	}
+}
+
+func TestWriteCSource(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("sched_yield()\npipe2(&(0x7f0000000000), 0x0)\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := writeCSource(dir, 42, 1, p, defaultCSourceOpts(target)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "prog42_1.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("int main(")) || !bytes.Contains(data, []byte("pipe2")) {
+		t.Fatalf("not a C reproducer of the program:\n%s", data)
+	}
+	opts := defaultCSourceOpts(target)
+	opts.Sandbox = "unknown"
+	if err := writeCSource(dir, 43, 1, p, opts); err == nil {
+		t.Fatalf("no error with bad options")
+	}
+}
+
+func TestCovDenylist(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "denylist")
+	data := "# noise\n0x10\n\n  32 # timer\n0xffffffff\n"
+	if err := os.WriteFile(fn, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deny, err := loadCovDenylist(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint32]bool{0x10: true, 32: true, 0xffffffff: true}
+	if !reflect.DeepEqual(deny, want) {
+		t.Fatalf("got deny list %v, want %v", deny, want)
+	}
+	if got := filterSignal([]uint32{1, 0x10, 2, 32}, deny); !reflect.DeepEqual(got, []uint32{1, 2}) {
+		t.Fatalf("got filtered signal %v", got)
+	}
+	ctx := &Context{covDenylist: deny}
+	info := &ipc.ProgInfo{Calls: []ipc.CallInfo{{Signal: []uint32{1, 0x10}}, {Signal: []uint32{1}}}}
+	if hashes := ctx.callsCovHash(info); hashes[0] != hashes[1] {
+		t.Fatalf("denied signal affects the hash: %v", hashes)
+	}
+	if err := os.WriteFile(fn, []byte("0x10\nfoo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCovDenylist(fn); err == nil {
+		t.Fatalf("bad deny list is loaded")
+	}
+}
+
+func TestParseInfluenceSweep(t *testing.T) {
+	tests := []struct {
+		list string
+		want []int
+		ok   bool
+	}{
+		{"100", []int{100}, true},
+		{"0, 50,100", []int{0, 50, 100}, true},
+		{"", nil, false},
+		{"50,", nil, false},
+		{"101", nil, false},
+		{"-1", nil, false},
+		{"half", nil, false},
+	}
+	for _, test := range tests {
+		got, err := parseInfluenceSweep(test.list)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v, want ok %v", test.list, err, test.ok)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.list, got, test.want)
+		}
+	}
+}
+
+func TestApplyInfluenceProportionSparse(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.SparseInfluence = nil
+	}()
+	var progs []*programEntry
+	for _, text := range []string{"getpid()\n", "pipe2(&(0x7f0000000000), 0x0)\ngetpid()\n"} {
+		p, err := target.Deserialize([]byte(text), prog.Strict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		progs = append(progs, &programEntry{p: p})
+	}
+	calls := usedSyscalls(progs)
+	if len(calls) != 2 || !calls[target.SyscallMap["getpid"].ID] || !calls[target.SyscallMap["pipe2"].ID] {
+		t.Fatalf("bad used syscalls: %v", calls)
+	}
+	target.SparseInfluence = make(map[prog.InfluenceEdge]bool)
+	for i := 0; i < 200; i++ {
+		target.SparseInfluence[prog.InfluenceEdge{Src: i, Dest: i + 1}] = true
+	}
+	applyInfluenceProportion(target, 50, rand.New(rand.NewSource(0)))
+	if got := influenceEdges(target); got != 100 || len(target.SparseInfluence) != 100 {
+		t.Fatalf("got %v edges (%v in the map), want 100", got, len(target.SparseInfluence))
+	}
+}
+
+func TestPrintTopInfluencers(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		target.SparseInfluence = nil
+	}()
+	id := func(name string) int {
+		return target.SyscallMap[name].ID
+	}
+	target.SparseInfluence = map[prog.InfluenceEdge]bool{
+		{Src: id("pipe2"), Dest: id("close")}:        true,
+		{Src: id("pipe2"), Dest: id("dup")}:          true,
+		{Src: id("dup"), Dest: id("close")}:          true,
+		{Src: id("getpid"), Dest: id("sched_yield")}: true,
+		{Src: id("getpid"), Dest: id("getpid")}:      true,
+	}
+	buf := new(bytes.Buffer)
+	printTopInfluencers(buf, target, 2)
+	want := "pipe2: 2 outgoing, 0 incoming influence edges\n" +
+		"getpid: 2 outgoing, 1 incoming influence edges\n"
+	if id("getpid") < id("pipe2") {
+		want = "getpid: 2 outgoing, 1 incoming influence edges\n" +
+			"pipe2: 2 outgoing, 0 incoming influence edges\n"
+	}
+	if buf.String() != want {
+		t.Fatalf("got:\n%v\nwant:\n%v", buf.String(), want)
+	}
+}
+
+func TestParseKernelUpperBase(t *testing.T) {
+	tests := []struct {
+		kallsyms string
+		want     uint32
+		ok       bool
+	}{
+		{"ffffffff81000000 T _text\nffffffff81000000 T startup_64\n", 0xffffffff, true},
+		{"  ffffffc010000000 t _head\n", 0xffffffc0, true},
+		{"ffff800008000000\tT _text\n", 0xffff8000, true},
+		{"c1000000 T _text\n", 0, true},
+		{"0000000000000000 T _text\n", 0, false},
+		{"_text T ffffffff81000000\n", 0, false},
+		{"1ffffffff81000000 T _text\n", 0, false},
+		{"\n", 0, false},
+		{"", 0, false},
+	}
+	for i, test := range tests {
+		got, err := parseKernelUpperBase(strings.NewReader(test.kallsyms))
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("#%v: got %#x/%v, want %#x/%v", i, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseOutPath(t *testing.T) {
+	data := "0\n" +
+		"current idx:idx\n" +
+		"0\n" +
+		"10,7,3\n" +
+		"\n" +
+		"  \n" +
+		"garbage\n" +
+		"-1\n" +
+		"12x\n" +
+		" 5 \n" +
+		"current idx:idx\n" +
+		"5\n" +
+		"8,8,0\n" +
+		"7"
+	done, err := parseOutPath(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]bool{0: true, 5: true, 7: true}; !reflect.DeepEqual(done, want) {
+		t.Fatalf("got %v, want %v", done, want)
+	}
+	done, err = parseOutPath(strings.NewReader("garbage\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Fatalf("malformed lines are marked as minimized: %v", done)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progs []*programEntry
+	for _, prog0 := range []struct {
+		text      string
+		callIndex int
+	}{
+		{"getpid()\n", 0},
+		{"getpid()\ngetuid()\n", 1},
+		{"getpid()\n", 0},
+		{"getpid()\ngetuid()\n", 0}, // the same program, but a different call is preserved
+		{"getpid()\ngetuid()\n", 1},
+		{"getpid()\n", 0},
+		{"getuid()\n", 0},
+		{"getuid()\n", 0},
+	} {
+		p, err := target.Deserialize([]byte(prog0.text), prog.Strict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		progs = append(progs, &programEntry{p: p, callIndex: prog0.callIndex})
+	}
+	duplicateOf := findDuplicates(progs, map[int]bool{6: true})
+	if want := map[int]int{2: 0, 4: 1, 5: 0}; !reflect.DeepEqual(duplicateOf, want) {
+		t.Fatalf("got duplicates %v, want %v", duplicateOf, want)
+	}
+	if got, want := groupDuplicates(duplicateOf), map[int][]int{0: {2, 5}, 1: {4}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got groups %v, want %v", got, want)
+	}
+}
+
+func TestSyscallTimes(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := newSyscallTimes()
+	var wg sync.WaitGroup
+	for _, exec := range []struct {
+		text    string
+		elapsed time.Duration
+	}{
+		{"getpid()\ngetpid()\n", time.Second},
+		{"getpid()\ngetuid()\n", 2 * time.Second},
+		{"sched_yield()\n", 5 * time.Second},
+		{"getuid()\n", 4 * time.Second},
+	} {
+		p, err := target.Deserialize([]byte(exec.text), prog.Strict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(elapsed time.Duration) {
+			defer wg.Done()
+			st.add(p, elapsed)
+		}(exec.elapsed)
+	}
+	wg.Wait()
+	buf := new(bytes.Buffer)
+	st.print(buf, 2)
+	want := "getuid: 6s in 2 executions\n" +
+		"sched_yield: 5s in 1 executions\n"
+	if buf.String() != want {
+		t.Fatalf("got:\n%v\nwant:\n%v", buf.String(), want)
+	}
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	tests := []struct {
+		data string
+		want map[int]bool
+		ok   bool
+	}{
+		{"", map[int]bool{}, true},
+		{"1\n# comment\n\n 5 # done\n", map[int]bool{1: true, 5: true}, true},
+		// The last record is torn.
+		{"1\n12", map[int]bool{1: true}, true},
+		{"1\nfoo\n", nil, false},
+		{"-1\n", nil, false},
+		{"1,2\n", nil, false},
+	}
+	for i, test := range tests {
+		got, err := parseCheckpoint([]byte(test.data))
+		if (err == nil) != test.ok {
+			t.Errorf("#%v: got error %v, want ok %v", i, err, test.ok)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("#%v: got %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "checkpoint")
+	done, err := loadCheckpoint(filename)
+	if err != nil || len(done) != 0 {
+		t.Fatalf("missing checkpoint: got %v, %v", done, err)
+	}
+	// Simulate a torn record left by an interrupted run.
+	if err := os.WriteFile(filename, []byte("3\n1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := openCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, idx := range []int{7, 10} {
+		if err := cp.markDone(idx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	done, err = loadCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]bool{3: true, 7: true, 10: true}; !reflect.DeepEqual(done, want) {
+		t.Fatalf("got %v, want %v", done, want)
+	}
+}
+
+func TestMinimizePasses(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("getpid()\ngetuid()\ngetgid()\nsched_yield()\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every pass removes only the first call until the preserved one becomes the first.
+	removeFirst := func(p *prog.Prog, callIndex int) (*prog.Prog, int, error) {
+		if callIndex == 0 {
+			return p.Clone(), callIndex, nil
+		}
+		p = p.Clone()
+		p.RemoveCall(0)
+		return p, callIndex - 1, nil
+	}
+	tests := []struct {
+		maxPasses int
+		passes    int
+		calls     int
+	}{
+		{1, 1, 3},
+		{2, 2, 2},
+		// The 4th pass makes no change and stops the loop.
+		{10, 4, 1},
+	}
+	for _, test := range tests {
+		p1, ci, passes, err := minimizePasses(p, 3, test.maxPasses, removeFirst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if passes != test.passes || len(p1.Calls) != test.calls || p1.Calls[ci].Meta.Name != "sched_yield" {
+			t.Errorf("max %v passes: got %v passes, %v calls, call index %v, want %v passes, %v calls",
+				test.maxPasses, passes, len(p1.Calls), ci, test.passes, test.calls)
+		}
+	}
+}
+
+func TestMinimizationFailed(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("getpid()\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A bad call index makes the minimizer return an error instead of panicking.
+	_, _, _, err = minimizePasses(p, 1, 1, func(p *prog.Prog, callIndex int) (*prog.Prog, int, error) {
+		p1, callIndex1, _, err := prog.MinimizeWithStats(p, callIndex, false,
+			func(*prog.Prog, int, int) bool { return true }, prog.MinimizeOptions{})
+		return p1, callIndex1, err
+	})
+	if err == nil {
+		t.Fatalf("no error for a bad call index")
+	}
+	filename := filepath.Join(t.TempDir(), "out")
+	out, err := openOutFile(filename, time.Millisecond, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{out: out, minimized: make(map[int]bool)}
+	ctx.minimizationFailed(3, "prog3", err)
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.isMinimized(3) || ctx.summary.failed != 1 || ctx.progress.failed != 1 {
+		t.Fatalf("the failed program is not skipped: minimized %v, failed %v/%v",
+			ctx.isMinimized(3), ctx.summary.failed, ctx.progress.failed)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := parseOutPath(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Fatalf("a resumed run would skip the failed program: %v", done)
+	}
+}
+
+func TestFormatCover(t *testing.T) {
+	pcs := []uint64{0xffffffff81000010, 0x1234}
+	tests := []struct {
+		format  string
+		ptrSize uint64
+		want    []byte
+	}{
+		{coverFormatText, 8, []byte("0xffffffff81000010\n0x1234\n")},
+		{coverFormatRaw, 8, []byte{0x10, 0x00, 0x00, 0x81, 0xff, 0xff, 0xff, 0xff,
+			0x34, 0x12, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{coverFormatRaw, 4, []byte{0x10, 0x00, 0x00, 0x81, 0x34, 0x12, 0x00, 0x00}},
+	}
+	for _, test := range tests {
+		if got := formatCover(pcs, test.format, test.ptrSize); !bytes.Equal(got, test.want) {
+			t.Errorf("%v/%v: got %x, want %x", test.format, test.ptrSize, got, test.want)
+		}
+	}
+}
+
+func TestOutFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "out")
+	if err := os.WriteFile(filename, []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := openOutFile(filename, time.Millisecond, "# header\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := out.append(fmt.Sprintf("%v\n", i)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	sort.Slice(lines, func(i, j int) bool {
+		a, _ := strconv.Atoi(lines[i])
+		b, _ := strconv.Atoi(lines[j])
+		return a < b
+	})
+	for i, line := range lines {
+		if line != fmt.Sprint(i) || len(lines) != 11 {
+			t.Fatalf("bad out file contents:\n%s", data)
+		}
+	}
+}
+
+func TestFoldCover(t *testing.T) {
+	sym := &symbolizer{[]*backend.Symbol{
+		{ObjectUnit: backend.ObjectUnit{Name: "foo"}, Start: 0x100, End: 0x200},
+		{ObjectUnit: backend.ObjectUnit{Name: "bar"}, Start: 0x200, End: 0x280},
+	}}
+	pcs := []uint64{0x210, 0x100, 0x1ff, 0x300, 0x220}
+	buf := new(bytes.Buffer)
+	foldCover(buf, "open", pcs, sym)
+	if got, want := buf.String(), "open;0x300 1\nopen;bar 2\nopen;foo 2\n"; got != want {
+		t.Errorf("got:\n%vwant:\n%v", got, want)
+	}
+	// Without symbols every PC is a function of its own.
+	buf.Reset()
+	foldCover(buf, "extra", pcs[:2], nil)
+	if got, want := buf.String(), "extra;0x100 1\nextra;0x210 1\n"; got != want {
+		t.Errorf("got:\n%vwant:\n%v", got, want)
+	}
+}
+
+func TestSameCallResult(t *testing.T) {
+	signal := []uint32{1, 2, 3}
+	hash := prog.SignalHash(signal)
+	tests := []struct {
+		inf        ipc.CallInfo
+		matchErrno bool
+		want       bool
+	}{
+		{ipc.CallInfo{Signal: signal, Errno: 22}, false, true},
+		{ipc.CallInfo{Signal: signal, Errno: 0}, false, true},
+		{ipc.CallInfo{Signal: signal, Errno: 22}, true, true},
+		{ipc.CallInfo{Signal: signal, Errno: 0}, true, false},
+		{ipc.CallInfo{Signal: signal[:2], Errno: 22}, true, false},
+	}
+	for i, test := range tests {
+		if got := sameCallResult(&test.inf, hash, 22, test.matchErrno); got != test.want {
+			t.Errorf("#%v: got %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestLookupPredicate(t *testing.T) {
+	pred, err := lookupPredicate(defaultPredicate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lookupPredicate("unknown"); err == nil || !strings.Contains(err.Error(), defaultPredicate) {
+		t.Fatalf("got error %v for an unknown predicate, want the list of predicates", err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("no panic on a duplicate predicate")
+			}
+		}()
+		registerPredicate(defaultPredicate, pred)
+	}()
+	executed := func(errno int, signal ...uint32) *execution {
+		info := &ipc.ProgInfo{Calls: []ipc.CallInfo{{}, {Flags: ipc.CallExecuted, Errno: errno, Signal: signal}}}
+		return &execution{callIndex: 1, info: info}
+	}
+	orig := executed(22, 1, 2, 3)
+	// The candidate call may have a different index.
+	cand := executed(0, 1, 2, 3)
+	cand.info.Calls = cand.info.Calls[1:]
+	cand.callIndex = 0
+	if !pred(orig, cand) {
+		t.Errorf("the same signal doesn't satisfy the default predicate")
+	}
+	if pred(orig, executed(22, 1, 2)) {
+		t.Errorf("a different signal satisfies the default predicate")
+	}
+}
+
+func TestStableBaseline(t *testing.T) {
+	executed := func(errno int, signal ...uint32) *ipc.ProgInfo {
+		return &ipc.ProgInfo{Calls: []ipc.CallInfo{{Flags: ipc.CallExecuted, Errno: errno, Signal: signal}}}
+	}
+	notExecuted := &ipc.ProgInfo{Calls: []ipc.CallInfo{{}}}
+	a, b, c := executed(0, 1, 2), executed(0, 1, 3), executed(22, 1, 2)
+	a2, b2 := executed(0, 1, 2), executed(0, 1, 3)
+	tests := []struct {
+		infos    []*ipc.ProgInfo
+		want     *ipc.ProgInfo
+		unstable bool
+	}{
+		{[]*ipc.ProgInfo{a}, a, false},
+		{[]*ipc.ProgInfo{a, a2}, a, false},
+		{[]*ipc.ProgInfo{b, a, a2}, a, true},
+		// The earliest result wins a tie.
+		{[]*ipc.ProgInfo{b, a, b2, a2}, b, true},
+		// Different errno means different behavior.
+		{[]*ipc.ProgInfo{c, a, a2}, a, true},
+		{[]*ipc.ProgInfo{notExecuted, a}, a, true},
+		{[]*ipc.ProgInfo{notExecuted, notExecuted}, notExecuted, true},
+	}
+	for i, test := range tests {
+		got, unstable := stableBaseline(test.infos, 0)
+		if got != test.want || unstable != test.unstable {
+			t.Errorf("#%v: got %p/%v, want %p/%v", i, got, unstable, test.want, test.unstable)
+		}
+	}
+}
+
+func TestRetentionPath(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("r0 = getpid()\n"+
+		"sched_yield()\n"+
+		"pipe2(&(0x7f0000000000), 0x0)\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := retentionPath(p, []int{0, 2}), "getpid -> pipe2"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteHeapProfile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "mem.prof")
+	// The second write overwrites the first profile.
+	for i := 0; i < 2; i++ {
+		if err := writeHeapProfile(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st, err := os.Stat(fn); err != nil || st.Size() == 0 {
+		t.Fatalf("bad profile: %v", err)
+	}
+	if _, err := os.Stat(fn + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("the temporary file is not removed: %v", err)
+	}
+}
+
+func TestMemoryLimitedProcs(t *testing.T) {
+	tests := []struct {
+		procs, maxMem, workerMem int
+		want                     int
+	}{
+		{16, 1024, 64, 16},
+		{16, 512, 64, 8},
+		{16, 500, 64, 7},
+		{4, 100000, 64, 4},
+		// A single worker runs even if it doesn't fit.
+		{16, 10, 64, 1},
+	}
+	for _, test := range tests {
+		if got := memoryLimitedProcs(test.procs, test.maxMem, test.workerMem); got != test.want {
+			t.Errorf("procs %v, maxmem %v, workermem %v: got %v, want %v",
+				test.procs, test.maxMem, test.workerMem, got, test.want)
+		}
+	}
+}
+
+func TestWatchNewPrograms(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"prog_0", "prog_1", ".prog_2.tmp", "prog_3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("getpid()\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	known := map[string]bool{dir + "/prog_1": true}
+	files, err := newProgramFiles(dir, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{dir + "/prog_0", dir + "/prog_3"}; !reflect.DeepEqual(files, want) {
+		t.Fatalf("got new files %v, want %v", files, want)
+	}
+
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(paths []string, indices []int) {
+		file_path_ary, call_index_ary = paths, indices
+	}(file_path_ary, call_index_ary)
+	file_path_ary, call_index_ary = nil, nil
+	// A file removed after it was listed is skipped instead of failing the run.
+	entries, loaded := loadNewProgramFiles(target, []string{dir + "/prog_0", dir + "/prog_4"})
+	if want := []string{dir + "/prog_0"}; !reflect.DeepEqual(loaded, want) ||
+		!reflect.DeepEqual(file_path_ary, want) || !reflect.DeepEqual(call_index_ary, []int{0}) {
+		t.Fatalf("got loaded files %v, file_path_ary %v, call_index_ary %v", loaded, file_path_ary, call_index_ary)
+	}
+	if len(entries) != 1 || entries[0].file != dir+"/prog_0" {
+		t.Fatalf("got %v new programs, want 1 from prog_0", len(entries))
+	}
+	p, err := target.Deserialize([]byte("getpid()\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{
+		progs:   []*programEntry{{p: p, callIndex: 0}},
+		invalid: make(map[int]bool),
+		repeat:  1,
+		pos:     5, // workers took indices past the end before they stopped
+	}
+	ctx.progress.total = 1
+	ctx.addPrograms([]*programEntry{{p: p, callIndex: 0}, {p: p, callIndex: 1}})
+	if len(ctx.progs) != 3 || !ctx.invalid[2] || ctx.invalid[1] || len(ctx.invalid) != 1 {
+		t.Fatalf("got %v programs, invalid %v", len(ctx.progs), ctx.invalid)
+	}
+	if idx := ctx.getProgramIndex(); idx != 1 {
+		t.Fatalf("got next program index %v, want 1", idx)
+	}
+	if ctx.progress.total != 2 {
+		t.Fatalf("got %v programs in progress, want 2", ctx.progress.total)
+	}
+}
+
+func TestParseSubsystem(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls, err := parseSubsystem(target, ""); calls != nil || err != nil {
+		t.Fatalf("empty list: got %v, %v", calls, err)
+	}
+	calls, err := parseSubsystem(target, "bind$, sendmsg$inet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range target.Syscalls {
+		want := strings.HasPrefix(c.Name, "bind$") || strings.HasPrefix(c.Name, "sendmsg$inet")
+		if calls[c.ID] != want {
+			t.Errorf("%v: got %v, want %v", c.Name, calls[c.ID], want)
+		}
+	}
+	if calls[target.SyscallMap["bind"].ID] || !calls[target.SyscallMap["bind$inet"].ID] {
+		t.Errorf("bad prefix match")
+	}
+	for _, list := range []string{"nosuchcall$", "bind$,", "bind$,foo"} {
+		if _, err := parseSubsystem(target, list); err == nil {
+			t.Errorf("%q: no error", list)
+		}
+	}
+}
+
+func TestParseSyscallList(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep, err := parseSyscallList(target, "mmap, pipe2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"mmap": true, "pipe2": true}; !reflect.DeepEqual(keep, want) {
+		t.Fatalf("got %v, want %v", keep, want)
+	}
+	if keep, err := parseSyscallList(target, ""); keep != nil || err != nil {
+		t.Fatalf("empty list: got %v, %v", keep, err)
+	}
+	if _, err := parseSyscallList(target, "pipe2,foo"); err == nil {
+		t.Fatalf("no error for an unknown syscall")
+	}
+}
+
+func TestOrderedOutput(t *testing.T) {
+	oo := newOrderedOutput()
+	oo.add(1, "1a\n")
+	oo.add(2, "2\n")
+	oo.add(1, "1b\n")
+	if got := oo.finish(1); got != "" {
+		t.Fatalf("output of program 1 is released before program 0: %q", got)
+	}
+	if got := oo.finish(2); got != "" {
+		t.Fatalf("output of program 2 is released before program 0: %q", got)
+	}
+	// Program 0 finished without any output (e.g. was skipped).
+	if got, want := oo.finish(0), "1a\n1b\n2\n"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	oo.add(4, "4\n")
+	oo.add(5, "5\n")
+	oo.finish(5)
+	if got, want := oo.drain(), "4\n5\n"; got != want {
+		t.Fatalf("drain: got %q, want %q", got, want)
+	}
+	if got := oo.finish(3); got != "" {
+		t.Fatalf("drained output is released again: %q", got)
+	}
+}
+
+func TestCheckBaselineCall(t *testing.T) {
+	executed := ipc.CallInfo{Flags: ipc.CallExecuted | ipc.CallFinished, Signal: []uint32{1}}
+	tests := []struct {
+		info      *ipc.ProgInfo
+		callIndex int
+		ok        bool
+	}{
+		{&ipc.ProgInfo{Calls: []ipc.CallInfo{{}, executed}}, 1, true},
+		{nil, 0, false},
+		{&ipc.ProgInfo{Calls: []ipc.CallInfo{executed}}, 1, false},
+		// The target call wasn't executed (e.g. the program was cut off by a blocking call).
+		{&ipc.ProgInfo{Calls: []ipc.CallInfo{executed, {}}}, 1, false},
+		{&ipc.ProgInfo{Calls: []ipc.CallInfo{{Flags: ipc.CallExecuted}}}, 0, false},
+	}
+	for i, test := range tests {
+		if err := checkBaselineCall(test.info, test.callIndex); (err == nil) != test.ok {
+			t.Errorf("#%v: got error %v, want ok %v", i, err, test.ok)
+		}
+	}
+}
+
+func TestLoadProgramFileSyz(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const text = "getpid()\npipe2(&(0x7f0000000000), 0x0)\n"
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "repro.syz")
+	if err := os.WriteFile(fn, []byte("# https://syzkaller.appspot.com/bug?id=0\n"+text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	progs, errs, err := loadProgramFile(target, fn, formatSyz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range errs {
+		t.Error(err)
+	}
+	if len(progs) != 1 || string(progs[0].Serialize()) != text {
+		t.Fatalf("loaded %v programs, want the original program", len(progs))
+	}
+	bad := filepath.Join(dir, "bad.syz")
+	if err := os.WriteFile(bad, []byte("foo(\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	progs, errs, err = loadProgramFile(target, bad, formatSyz)
+	if err != nil || len(progs) != 0 || len(errs) != 1 {
+		t.Fatalf("a bad program is loaded: %v programs, %v errors", len(progs), len(errs))
+	}
+	if errs[0].File != bad || errs[0].Record != "" {
+		t.Errorf("bad error location: %+v", errs[0])
+	}
+}
+
+func TestExecRetryDelay(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 30 * time.Second, 30 * time.Second}
+	for try, delay := range want {
+		if got := execRetryDelay(try); got != delay {
+			t.Errorf("try %v: got delay %v, want %v", try, got, delay)
+		}
+	}
+}
+
+func TestFailureLog(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "failed")
+	fl, err := openFailureLog(fn, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, idx := range []int{3, 5, 8} {
+		abort, err := fl.record(idx, "corpus.db", fmt.Errorf("executor failed\nSYZFAIL"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i == 2; abort != want {
+			t.Fatalf("failure %v: abort %v, want %v", i, abort, want)
+		}
+		if want := i == 2; fl.exceeded() != want {
+			t.Fatalf("failure %v: exceeded %v, want %v", i, fl.exceeded(), want)
+		}
+	}
+	if err := fl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3\tcorpus.db\texecutor failed SYZFAIL\n" +
+		"5\tcorpus.db\texecutor failed SYZFAIL\n" +
+		"8\tcorpus.db\texecutor failed SYZFAIL\n"
+	if string(data) != want {
+		t.Fatalf("got failures:\n%s\nwant:\n%s", data, want)
+	}
+	unlimited, err := openFailureLog("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if abort, _ := unlimited.record(i, "", fmt.Errorf("failed")); abort {
+			t.Fatalf("unlimited failure log aborted after %v failures", i+1)
+		}
+	}
+	if unlimited.failures() != 100 {
+		t.Fatalf("got %v failures, want 100", unlimited.failures())
+	}
+}
+
+func TestRecordFailureStops(t *testing.T) {
+	failures, err := openFailureLog("", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan struct{})
+	var once sync.Once
+	ctx := &Context{
+		shutdown: shutdown,
+		stop:     func() { once.Do(func() { close(shutdown) }) },
+		failures: failures,
+	}
+	// Exceeding -maxfailures stops the workers instead of exiting,
+	// so that main still closes the output files.
+	for i := 0; i < 3; i++ {
+		ctx.recordFailure(i, "prog", fmt.Errorf("executor failed"))
+		if want := i >= 1; ctx.interrupted() != want || failures.exceeded() != want {
+			t.Fatalf("failure %v: interrupted %v, exceeded %v, want %v",
+				i, ctx.interrupted(), failures.exceeded(), want)
+		}
+	}
+}
+
+func TestVerdictsReplay(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("getpid()\ngetuid()\ngetgid()\nsched_yield()\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(t.TempDir(), "verdicts")
+	recorder, err := openVerdictRecorder(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := prog.MinimizeOptions{NoInfluence: true}
+	// Candidates are accepted as long as they keep getgid.
+	p1, callIndex1, err := prog.MinimizeWithOptions(p, 3, false,
+		func(p1 *prog.Prog, callIndex, minimizeType int) bool {
+			ok := false
+			for _, c := range p1.Calls {
+				ok = ok || c.Meta.Name == "getgid"
+			}
+			recorder.record(7, p1, minimizeType, ok)
+			return ok
+		}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	verdicts, err := loadVerdicts(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verdicts) != 1 || len(verdicts[7]) == 0 {
+		t.Fatalf("got verdicts for programs %v, want only 7", verdicts)
+	}
+	replayer := &verdictReplayer{verdicts: verdicts[7]}
+	p2, callIndex2, err := prog.MinimizeWithOptions(p, 3, false, replayer.pred, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayer.err != nil || replayer.pos != len(replayer.verdicts) {
+		t.Fatalf("replay used %v of %v verdicts: %v", replayer.pos, len(replayer.verdicts), replayer.err)
+	}
+	if callIndex2 != callIndex1 || !bytes.Equal(p2.Serialize(), p1.Serialize()) {
+		t.Fatalf("replay produced call %v of\n%s\nwant call %v of\n%s", callIndex2, p2.Serialize(),
+			callIndex1, p1.Serialize())
+	}
+	// A different program diverges on the first step.
+	other, err := target.Deserialize([]byte("getpid()\ngetgid()\nsched_yield()\ngetuid()\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayer = &verdictReplayer{verdicts: verdicts[7]}
+	if _, _, err := prog.MinimizeWithOptions(other, 3, false, replayer.pred, opts); err != nil {
+		t.Fatal(err)
+	}
+	if replayer.err == nil || replayer.pos != 0 {
+		t.Fatalf("replay of a different program did not diverge: pos %v, err %v", replayer.pos, replayer.err)
+	}
+}
+
+func TestParseVerdicts(t *testing.T) {
+	verdicts, err := parseVerdicts("1 aa 1 0\n0 bb 2 1\n1 cc 3 1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int][]verdict{
+		0: {{"bb", 2, true}},
+		1: {{"aa", 1, false}, {"cc", 3, true}},
+	}
+	if !reflect.DeepEqual(verdicts, want) {
+		t.Fatalf("got %+v, want %+v", verdicts, want)
+	}
+	for _, bad := range []string{"1 aa 1\n", "x aa 1 0\n", "1 aa 1 2\n", "-1 aa 1 1\n"} {
+		if _, err := parseVerdicts(bad); err == nil {
+			t.Errorf("parsed bad verdicts %q", bad)
+		}
+	}
+}
+
+func TestLoadProgramFileCorruptRecords(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const text = "pipe2(&(0x7f0000000000), 0x0)\n"
+	fn := filepath.Join(t.TempDir(), "corpus.db")
+	records := []db.Record{{Val: []byte(text)}, {Val: []byte("foo(\n")}, {Val: []byte("getpid(\n")}}
+	if err := db.Create(fn, 0, records); err != nil {
+		t.Fatal(err)
+	}
+	progs, errs, err := loadProgramFile(target, fn, formatDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progs) != 1 || string(progs[0].Serialize()) != text {
+		t.Fatalf("loaded %v programs, want the good one", len(progs))
+	}
+	if len(errs) != 2 {
+		t.Fatalf("got %v errors, want 2: %v", len(errs), errs)
+	}
+	for _, err := range errs {
+		if err.File != fn || err.Record == "" || err.Unwrap() == nil {
+			t.Errorf("error does not name the file and the record: %+v", err)
+		}
+		if !strings.HasPrefix(err.Error(), fn+": record "+err.Record+": ") {
+			t.Errorf("bad error message: %v", err)
+		}
+	}
+	if progs, err := loadProgramFileReport(target, fn, formatDB, 1); err != nil || len(progs) != 1 {
+		t.Fatalf("loaded %v programs, want the good one", len(progs))
+	}
+}
+
+func TestLoadProgramFileDBOrder(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(t.TempDir(), "corpus.db")
+	var records []db.Record
+	for i := 0; i < 16; i++ {
+		records = append(records, db.Record{Val: []byte(fmt.Sprintf("pipe2(&(0x7f0000000000), 0x%x)\n", i))})
+	}
+	if err := db.Create(fn, 0, records); err != nil {
+		t.Fatal(err)
+	}
+	load := func() []string {
+		progs, errs, err := loadProgramFile(target, fn, formatDB)
+		if err != nil || len(errs) != 0 || len(progs) != len(records) {
+			t.Fatalf("loaded %v programs with errors %v, want %v programs", len(progs), errs, len(records))
+		}
+		var res []string
+		for _, p := range progs {
+			res = append(res, string(p.Serialize()))
+		}
+		return res
+	}
+	// Program indices must not depend on the map iteration order.
+	order := load()
+	for i := 0; i < 5; i++ {
+		if got := load(); !reflect.DeepEqual(got, order) {
+			t.Fatalf("programs are loaded in a different order:\n%q\nwant:\n%q", got, order)
+		}
+	}
+}
+
+// testExecutor returns the same errno for every call of a program, depending on whether
+// the program contains the given syscall.
+type testExecutor struct {
+	syscall string
+	errno   int
+	// last is the last executed program.
+	last *prog.Prog
+}
+
+func (te *testExecutor) Exec(opts *ipc.ExecOpts, p *prog.Prog) ([]byte, *ipc.ProgInfo, bool, error) {
+	te.last = p
+	errno := 0
+	for _, c := range p.Calls {
+		if c.Meta.Name == te.syscall {
+			errno = te.errno
+		}
+	}
+	info := &ipc.ProgInfo{Calls: make([]ipc.CallInfo, len(p.Calls))}
+	for i := range info.Calls {
+		info.Calls[i] = ipc.CallInfo{Flags: ipc.CallExecuted, Signal: []uint32{1}, Errno: errno}
+	}
+	return nil, info, false, nil
+}
+
+func TestDifferentialPred(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("getpid()\ngetuid()\ngetgid()\nsched_yield()\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The "patched" executor fails calls of programs that contain getuid.
+	env1 := &testExecutor{}
+	env2 := &testExecutor{syscall: "getuid", errno: 22}
+	_, info1, _, _ := env1.Exec(nil, p)
+	_, info2, _, _ := env2.Exec(nil, p)
+	if err := checkBaselineDivergence(info1, info2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBaselineDivergence(info1, info1, 3); err == nil {
+		t.Fatalf("same behavior is reported as a divergence")
+	}
+	execs := 0
+	pred := differentialPred(env1, env2, nil, 2, func(p *prog.Prog, minimizeType int, elapsed time.Duration) {
+		execs++
+	})
+	p1, callIndex, err := prog.MinimizeWithOptions(p, 3, false, pred, prog.MinimizeOptions{NoInfluence: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(p1.Serialize()), "getuid()\nsched_yield()\n"; got != want || callIndex != 1 {
+		t.Fatalf("got call %v of\n%v\nwant call 1 of\n%v", callIndex, got, want)
+	}
+	if execs == 0 {
+		t.Fatalf("onExec is not invoked")
+	}
+}
+
+func TestMetadataHeader(t *testing.T) {
+	md := &runMetadata{
+		OS:                  "linux",
+		Arch:                "amd64",
+		Revision:            "0123456789abcdef",
+		Seed:                42,
+		InfluenceProportion: 50,
+		Learn:               true,
+		Reexec:              3,
+	}
+	filename := filepath.Join(t.TempDir(), "out")
+	// The header is written to a fresh file only, a resumed run appends after it.
+	for i := 0; i < 2; i++ {
+		out, err := openOutFile(filename, time.Hour, md.header())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := out.append(fmt.Sprintf("%v\n", i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := out.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("bad out file contents:\n%s", data)
+	}
+	md1, err := parseMetadataHeader(lines[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(md, md1) {
+		t.Fatalf("got metadata %+v, want %+v", md1, md)
+	}
+	done, err := parseOutPath(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]bool{0: true, 1: true}; !reflect.DeepEqual(done, want) {
+		t.Fatalf("got minimized programs %v, want %v", done, want)
+	}
+	if _, err := parseMetadataHeader(lines[1]); err == nil {
+		t.Fatalf("parsed a program index as the metadata header")
+	}
+}
+
+func TestMustHit(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "musthit")
+	const data = "# edges leading to the bug\n0xffffffff81000010\n0xffffffff81000020 # the bug\n"
+	if err := os.WriteFile(fn, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	must, err := loadMustHit(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[uint64]bool{0xffffffff81000010: true, 0xffffffff81000020: true}; !reflect.DeepEqual(must, want) {
+		t.Fatalf("got PCs %v, want %v", must, want)
+	}
+	restore := func(pc uint32) uint64 {
+		return 0xffffffff00000000 | uint64(pc)
+	}
+	tests := []struct {
+		cover []uint32
+		want  bool
+	}{
+		{[]uint32{0x81000010, 0x81000020}, true},
+		// Extra and repeated coverage doesn't matter.
+		{[]uint32{0x81000030, 0x81000020, 0x81000020, 0x81000010}, true},
+		{[]uint32{0x81000020, 0x81000020}, false},
+		{nil, false},
+	}
+	for i, test := range tests {
+		if got := coversAll(test.cover, must, restore); got != test.want {
+			t.Errorf("test #%v: got %v, want %v", i, got, test.want)
+		}
+	}
+	for _, bad := range []string{"", "0xfoo\n"} {
+		if err := os.WriteFile(fn, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadMustHit(fn); err == nil {
+			t.Errorf("loaded bad PCs %q", bad)
+		}
+	}
+}
+
+func TestVerifyMinimized(t *testing.T) {
+	tests := []struct {
+		verdicts []bool
+		passed   int
+		unstable bool
+	}{
+		{[]bool{true, true, true}, 3, false},
+		{[]bool{true, false, true}, 2, false},
+		{[]bool{false, true, false}, 1, true},
+		{[]bool{true, false}, 1, false},
+		{[]bool{false, false, false, true}, 1, true},
+	}
+	for i, test := range tests {
+		runs := 0
+		pred := func(p *prog.Prog, callIndex, minimizeType int) bool {
+			runs++
+			return test.verdicts[runs-1]
+		}
+		passed := verifyMinimized(nil, 0, len(test.verdicts), pred)
+		if passed != test.passed || runs != len(test.verdicts) {
+			t.Errorf("#%v: got %v passed of %v runs, want %v of %v", i, passed, runs, test.passed, len(test.verdicts))
+		}
+		res := &minimizeResult{verifyRuns: len(test.verdicts), verifyPassed: passed}
+		if res.unstable() != test.unstable {
+			t.Errorf("#%v: got unstable %v, want %v", i, res.unstable(), test.unstable)
+		}
+	}
+	if (&minimizeResult{}).unstable() {
+		t.Errorf("a program without verification is unstable")
+	}
+	var s runSummary
+	s.add(&minimizeResult{origCalls: 2, finalCalls: 1, verifyRuns: 3, verifyPassed: 1})
+	buf := new(bytes.Buffer)
+	s.print(buf)
+	if want := "1 minimized programs are unstable"; !strings.Contains(buf.String(), want) {
+		t.Errorf("the summary doesn't mention unstable programs:\n%v", buf.String())
+	}
+}
+
+func TestForceSync(t *testing.T) {
+	target, err := prog.GetTarget("test", "64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("test$res0() (async)\n"+
+		"test() (rerun: 3)\n"+
+		"test$res0() (fail_nth: 2)\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1 := forceSync(p)
+	if p1 == nil {
+		t.Fatalf("forceSync didn't reset the props")
+	}
+	if got, want := string(p1.Serialize()), "test$res0()\ntest()\ntest$res0()\n"; got != want {
+		t.Fatalf("got:\n%vwant:\n%v", got, want)
+	}
+	if p.Calls[0].Props.Async != true {
+		t.Fatalf("forceSync modified the original program")
+	}
+	if forceSync(p1) != nil {
+		t.Fatalf("forceSync copied a synchronous program")
+	}
+	var s runSummary
+	s.add(&minimizeResult{origCalls: 3, finalCalls: 3, nondeterministic: true})
+	buf := new(bytes.Buffer)
+	s.print(buf)
+	if want := "1 minimized programs require nondeterminism"; !strings.Contains(buf.String(), want) {
+		t.Errorf("the summary doesn't mention nondeterministic programs:\n%v", buf.String())
+	}
+}
+
+func TestParseSanitizerReport(t *testing.T) {
+	tests := []struct {
+		sanitizer string
+		output    string
+		want      *sanitizerReport
+	}{
+		{sanitizerKASAN, "no reports here\n", nil},
+		{
+			sanitizerKASAN,
+			"==================================================================\n" +
+				"BUG: KASAN: slab-use-after-free in tcp_v4_rcv+0x1234/0x2000\n" +
+				"Read of size 8 at addr ffff888012345678 by task syz-executor/123\n",
+			&sanitizerReport{"slab-use-after-free", "read", 8},
+		},
+		{
+			sanitizerKASAN,
+			"BUG: KASAN: null-ptr-deref in range [0x0000000000000010-0x0000000000000017]\n" +
+				"Write of size 4 at addr 0000000000000010 by task syz-executor/7\n",
+			&sanitizerReport{"null-ptr-deref", "write", 4},
+		},
+		// A KCSAN report is not a KASAN report.
+		{sanitizerKASAN, "BUG: KCSAN: data-race in foo / bar\n", nil},
+		{
+			sanitizerKCSAN,
+			"BUG: KCSAN: data-race in foo / bar\n\n" +
+				"write (marked) to 0xffff888012345678 of 4 bytes by task 1 on cpu 0:\n" +
+				" foo+0x10/0x20\n\n" +
+				"read to 0xffff888012345678 of 4 bytes by task 2 on cpu 1:\n",
+			&sanitizerReport{"data-race", "write", 4},
+		},
+		{
+			sanitizerKCSAN,
+			"BUG: KCSAN: data-race in foo / bar\n\nread-write to 0xffff888012345678 of 8 bytes by task 1:\n",
+			&sanitizerReport{"data-race", "read-write", 8},
+		},
+	}
+	for i, test := range tests {
+		got := parseSanitizerReport(test.sanitizer, []byte(test.output))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("#%v: got %+v, want %+v", i, got, test.want)
+		}
+	}
+}
+
+// testKernelLog returns a KASAN report if the last program executed by exec contains the syscall.
+type testKernelLog struct {
+	exec    *testExecutor
+	syscall string
+	report  string
+}
+
+func (kl *testKernelLog) newMessages() ([]byte, error) {
+	msgs := []byte(nil)
+	if kl.exec.last != nil {
+		for _, c := range kl.exec.last.Calls {
+			if c.Meta.Name == kl.syscall {
+				msgs = []byte(kl.report)
+			}
+		}
+	}
+	kl.exec.last = nil
+	return msgs, nil
+}
+
+func TestSanitizerPred(t *testing.T) {
+	target, err := prog.GetTarget("linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := target.Deserialize([]byte("getpid()\ngetuid()\ngetgid()\nsched_yield()\n"), prog.Strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &testExecutor{}
+	klog := &testKernelLog{exec: env, syscall: "getuid", report: "BUG: KASAN: use-after-free in foo\n" +
+		"Read of size 8 at addr ffff888012345678 by task syz-executor/1\n"}
+	if _, err := sanitizerBaseline(env, klog, nil, 2, sanitizerKASAN, "slab-out-of-bounds", p); err == nil {
+		t.Fatalf("got a report of a different type")
+	}
+	want, err := sanitizerBaseline(env, klog, nil, 2, sanitizerKASAN, "", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execs := 0
+	pred := sanitizerPred(env, klog, nil, 1, sanitizerKASAN, want,
+		func(p *prog.Prog, minimizeType int, elapsed time.Duration) {
+			execs++
+		})
+	p1, _, err := prog.MinimizeWithOptions(p, 3, true, pred, prog.MinimizeOptions{NoInfluence: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(p1.Serialize()); !strings.Contains(got, "getuid") || len(p1.Calls) > 2 {
+		t.Fatalf("bad minimized program:\n%v", got)
+	}
+	if execs == 0 {
+		t.Fatalf("executions are not counted")
+	}
+	// A report with a different access doesn't match.
+	klog.report = strings.Replace(klog.report, "Read", "Write", 1)
+	if pred(p, 3, 1) {
+		t.Fatalf("a report with a different access is accepted")
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	var s runSummary
+	buf := new(bytes.Buffer)
+	s.print(buf)
+	if want := "summary: 0 programs minimized, 0 skipped, 0 failed\n"; buf.String() != want {
+		t.Fatalf("got empty summary:\n%v\nwant:\n%v", buf.String(), want)
+	}
+	for _, calls := range [][2]int{{4, 4}, {10, 8}, {4, 3}, {4, 2}, {10, 1}, {8, 1}} {
+		s.add(&minimizeResult{origCalls: calls[0], finalCalls: calls[1]})
+	}
+	s.skip()
+	s.fail()
+	s.fail()
+	buf.Reset()
+	s.print(buf)
+	want := "summary: 6 programs minimized, 1 skipped, 2 failed\n" +
+		"avg calls 6.67 -> 3.17, overall reduction 52.5%\n" +
+		"  reduction 0%:      1 programs\n" +
+		"  reduction 1-24%:   1 programs\n" +
+		"  reduction 25-49%:  1 programs\n" +
+		"  reduction 50-74%:  1 programs\n" +
+		"  reduction 75%+:    2 programs\n"
+	if buf.String() != want {
+		t.Fatalf("got summary:\n%v\nwant:\n%v", buf.String(), want)
+	}
+}
+
+func TestCSVColumns(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.csv")
+	cw, err := openCSV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.write(&minimizeResult{idx: 3, file: "prog3", origCalls: 5, finalCalls: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("bad csv:\n%s", data)
+	}
+	header, row := strings.Split(lines[0], ","), strings.Split(lines[1], ",")
+	// The leading columns must stay in place for consumers of existing CSVs.
+	if want := []string{"idx", "orig_calls", "final_calls"}; !reflect.DeepEqual(header[:3], want) {
+		t.Fatalf("got leading columns %q, want %q", header[:3], want)
+	}
+	if len(row) != len(header) || row[0] != "3" || row[1] != "5" || row[2] != "2" || row[len(row)-1] != "prog3" {
+		t.Fatalf("row doesn't match the header:\n%s", data)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	execRetries    = 10
+	execBackoff    = time.Second
+	execMaxBackoff = 30 * time.Second
+)
+
+// execRetryDelay returns the delay before retry number try (starting from 0) of a failed execution.
+// The delay doubles with every retry up to execMaxBackoff.
+func execRetryDelay(try int) time.Duration {
+	delay := execBackoff
+	for i := 0; i < try && delay < execMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > execMaxBackoff {
+		delay = execMaxBackoff
+	}
+	return delay
+}
+
+// failureLog accounts programs that were skipped because the executor kept failing on them.
+// Every failure is appended to the -failout file as "idx<TAB>file<TAB>error" line, if the file is used.
+type failureLog struct {
+	mu    sync.Mutex
+	file  *os.File
+	count int
+	max   int
+	// over is set once the failure threshold is exceeded.
+	over bool
+}
+
+// openFailureLog opens filename for appending, empty filename means that failures are only counted.
+// max is the number of failures tolerated before the run is aborted, 0 means unlimited.
+func openFailureLog(filename string, max int) (*failureLog, error) {
+	fl := &failureLog{max: max}
+	if filename != "" {
+		file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		fl.file = file
+	}
+	return fl, nil
+}
+
+// record records failure of program idx and returns true if the failure threshold is exceeded.
+func (fl *failureLog) record(idx int, file string, failure error) (bool, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.count++
+	var err error
+	if fl.file != nil {
+		msg := strings.ReplaceAll(failure.Error(), "\n", " ")
+		_, err = fmt.Fprintf(fl.file, "%v\t%v\t%v\n", idx, file, msg)
+	}
+	fl.over = fl.over || fl.max != 0 && fl.count > fl.max
+	return fl.over, err
+}
+
+// exceeded returns true if the failure threshold was exceeded and the run was aborted.
+func (fl *failureLog) exceeded() bool {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.over
+}
+
+func (fl *failureLog) failures() int {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.count
+}
+
+func (fl *failureLog) Close() error {
+	if fl.file == nil {
+		return nil
+	}
+	return fl.file.Close()
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+)
+
+// kmsgLog reads kernel messages from /dev/kmsg.
+type kmsgLog struct {
+	fd  int
+	buf []byte
+}
+
+// openKernelLog opens the kernel log, messages printed before the call are skipped.
+func openKernelLog() (kernelLog, error) {
+	fd, err := syscall.Open("/dev/kmsg", syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /dev/kmsg: %w", err)
+	}
+	if _, err := syscall.Seek(fd, 0, 2 /* SEEK_END */); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to seek /dev/kmsg: %w", err)
+	}
+	return &kmsgLog{fd: fd, buf: make([]byte, 8<<10)}, nil
+}
+
+func (kl *kmsgLog) newMessages() ([]byte, error) {
+	var msgs []byte
+	for {
+		// Every read returns a single record "prio,seq,time,flags;message\n" followed by dictionary lines.
+		n, err := syscall.Read(kl.fd, kl.buf)
+		if err == syscall.EAGAIN {
+			return msgs, nil
+		}
+		if err == syscall.EPIPE {
+			// Some records were overwritten before we read them, continue with the next one.
+			continue
+		}
+		if err != nil {
+			return msgs, fmt.Errorf("failed to read /dev/kmsg: %w", err)
+		}
+		record := kl.buf[:n]
+		if pos := bytes.IndexByte(record, ';'); pos != -1 {
+			record = record[pos+1:]
+		}
+		if pos := bytes.IndexByte(record, '\n'); pos != -1 {
+			record = record[:pos+1]
+		}
+		msgs = append(msgs, record...)
+	}
+}
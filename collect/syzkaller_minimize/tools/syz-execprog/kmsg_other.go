@@ -0,0 +1,16 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+)
+
+// openKernelLog fails, sanitizer reports are only read from /dev/kmsg on Linux.
+func openKernelLog() (kernelLog, error) {
+	return nil, fmt.Errorf("reading the kernel log is only supported on linux")
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+)
+
+// memProfileFile returns the file passed to -memprofile (registered by tool.Init), or "".
+// tool.Init writes the heap profile at shutdown, -memprofileinterval additionally writes it periodically.
+func memProfileFile() string {
+	if f := flag.Lookup("memprofile"); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// writeHeapProfile writes the heap profile to file fn. The profile is written to a temporary file first,
+// so that fn always holds a complete profile even if it's being overwritten periodically.
+func writeHeapProfile(fn string) error {
+	tmp := fn + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+// writeHeapProfilePeriodically overwrites heap profile fn every interval until stop is closed.
+func writeHeapProfilePeriodically(fn string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := writeHeapProfile(fn); err != nil {
+				log.Logf(0, "failed to write memory profile: %v", err)
+			}
+		}
+	}
+}
+
+// memProfileVerbosity returns the log verbosity of memory usage, it's always logged with -memprofile.
+func memProfileVerbosity() int {
+	if memProfileFile() != "" {
+		return 0
+	}
+	return 1
+}
+
+// logPeakRSS logs the peak resident set size of the process, if it's known, with what describes
+// the point of execution. On large targets the influence matrix alone takes hundreds of megabytes.
+func logPeakRSS(v int, what string) {
+	if rss := peakRSS(); rss != 0 {
+		log.Logf(v, "peak RSS %v: %v MB", what, rss>>20)
+	}
+}
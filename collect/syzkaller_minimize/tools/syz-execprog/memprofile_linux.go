@@ -0,0 +1,18 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"syscall"
+)
+
+// peakRSS returns the peak resident set size of the process in bytes.
+func peakRSS() uint64 {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	// Maxrss is in kilobytes on Linux.
+	return uint64(usage.Maxrss) << 10
+}
@@ -0,0 +1,12 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+// peakRSS returns 0, the peak resident set size is only reported on Linux.
+func peakRSS() uint64 {
+	return 0
+}
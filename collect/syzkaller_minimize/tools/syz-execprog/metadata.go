@@ -0,0 +1,47 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// metadataPrefix starts the metadata header line of the -outpath file.
+const metadataPrefix = "# syz-execprog "
+
+// runMetadata describes parameters of a run that affect minimization results,
+// so that the run can be reproduced or compared with other runs later.
+type runMetadata struct {
+	OS                  string `json:"os"`
+	Arch                string `json:"arch"`
+	Revision            string `json:"revision"`
+	Seed                int64  `json:"seed"`
+	InfluenceProportion int    `json:"influence_proportion"`
+	NoInfluence         bool   `json:"no_influence"`
+	Learn               bool   `json:"learn"`
+	Reexec              int    `json:"reexec"`
+}
+
+// header returns the metadata header line.
+func (md *runMetadata) header() string {
+	data, err := json.Marshal(md)
+	if err != nil {
+		panic(err)
+	}
+	return metadataPrefix + string(data) + "\n"
+}
+
+// parseMetadataHeader parses a line returned by runMetadata.header.
+func parseMetadataHeader(line string) (*runMetadata, error) {
+	if !strings.HasPrefix(line, metadataPrefix) {
+		return nil, fmt.Errorf("no metadata header")
+	}
+	md := new(runMetadata)
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, metadataPrefix)), md); err != nil {
+		return nil, fmt.Errorf("bad metadata header: %w", err)
+	}
+	return md, nil
+}
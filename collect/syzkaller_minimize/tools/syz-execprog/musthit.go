@@ -0,0 +1,74 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/syzkaller/pkg/cover"
+	"github.com/google/syzkaller/pkg/cover/backend"
+	"github.com/google/syzkaller/pkg/ipc"
+)
+
+// With -musthit a candidate is accepted if the preserved call still covers all PCs from the given
+// file instead of producing exactly the original signal. Exact signal is brittle under nondeterministic
+// coverage, while usually only a few edges matter (e.g. the ones leading to a bug). The file has
+// one PC per line (hex or decimal, '#' starts a comment), the text -coverfile output is accepted as is.
+func loadMustHit(filename string) (map[uint64]bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pcs := make(map[uint64]bool)
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if pos := strings.IndexByte(text, '#'); pos != -1 {
+			text = text[:pos]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		pc, err := strconv.ParseUint(text, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: bad PC %q: %w", filename, line, text, err)
+		}
+		pcs[pc] = true
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(pcs) == 0 {
+		return nil, fmt.Errorf("%v: no PCs", filename)
+	}
+	return pcs, nil
+}
+
+// coversAll returns true if cover contains all PCs in must.
+// restore converts raw coverage to PCs in the format of the must-hit file.
+func coversAll(cover []uint32, must map[uint64]bool, restore func(uint32) uint64) bool {
+	hit := 0
+	seen := make(map[uint64]bool)
+	for _, raw := range cover {
+		pc := restore(raw)
+		if must[pc] && !seen[pc] {
+			seen[pc] = true
+			hit++
+		}
+	}
+	return hit == len(must)
+}
+
+// hitsMustPCs returns true if the call covers all -musthit PCs.
+func (ctx *Context) hitsMustPCs(inf *ipc.CallInfo) bool {
+	return coversAll(inf.Cover, ctx.mustHit, func(pc uint32) uint64 {
+		return backend.PreviousInstructionPC(ctx.target, cover.RestorePC(pc, ctx.upperBase))
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// orderedOutput buffers -outpath output of programs that complete out of order
+// and releases it in ascending program index order. Only the output is ordered,
+// workers never wait for each other.
+type orderedOutput struct {
+	mu       sync.Mutex
+	next     int
+	pending  map[int]*strings.Builder
+	finished map[int]bool
+}
+
+func newOrderedOutput() *orderedOutput {
+	return &orderedOutput{
+		pending:  make(map[int]*strings.Builder),
+		finished: make(map[int]bool),
+	}
+}
+
+// add buffers text of program idx.
+func (oo *orderedOutput) add(idx int, text string) {
+	oo.mu.Lock()
+	defer oo.mu.Unlock()
+	buf := oo.pending[idx]
+	if buf == nil {
+		buf = new(strings.Builder)
+		oo.pending[idx] = buf
+	}
+	buf.WriteString(text)
+}
+
+// finish marks program idx as finished (whether it produced any output or not)
+// and returns the output that became ready to be written.
+func (oo *orderedOutput) finish(idx int) string {
+	oo.mu.Lock()
+	defer oo.mu.Unlock()
+	oo.finished[idx] = true
+	var ready strings.Builder
+	for oo.finished[oo.next] {
+		if buf := oo.pending[oo.next]; buf != nil {
+			ready.WriteString(buf.String())
+		}
+		delete(oo.pending, oo.next)
+		delete(oo.finished, oo.next)
+		oo.next++
+	}
+	return ready.String()
+}
+
+// drain returns all buffered output in ascending index order regardless of gaps,
+// e.g. for programs that finished before the run was interrupted.
+func (oo *orderedOutput) drain() string {
+	oo.mu.Lock()
+	defer oo.mu.Unlock()
+	var indices []int
+	for idx := range oo.pending {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+	var ready strings.Builder
+	for _, idx := range indices {
+		ready.WriteString(oo.pending[idx].String())
+	}
+	oo.pending = make(map[int]*strings.Builder)
+	return ready.String()
+}
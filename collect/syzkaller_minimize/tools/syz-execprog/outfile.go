@@ -0,0 +1,85 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"time"
+)
+
+// outFile appends minimization markers and stats to the -outpath file.
+// All procs write through one buffered writer over a single file handle,
+// which is flushed periodically and on Close.
+type outFile struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openOutFile opens filename for appending, so a restarted run continues the file.
+// header, if not empty, is written only if the file is empty.
+func openOutFile(filename string, flushPeriod time.Duration, header string) (*outFile, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	of := &outFile{
+		file: file,
+		w:    bufio.NewWriter(file),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if stat.Size() == 0 && header != "" {
+		of.w.WriteString(header)
+	}
+	go of.flushLoop(flushPeriod)
+	return of, nil
+}
+
+// append writes text atomically with respect to other appends.
+func (of *outFile) append(text string) error {
+	of.mu.Lock()
+	defer of.mu.Unlock()
+	_, err := of.w.WriteString(text)
+	return err
+}
+
+func (of *outFile) flush() error {
+	of.mu.Lock()
+	defer of.mu.Unlock()
+	return of.w.Flush()
+}
+
+func (of *outFile) flushLoop(period time.Duration) {
+	defer close(of.done)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			of.flush()
+		case <-of.stop:
+			return
+		}
+	}
+}
+
+func (of *outFile) Close() error {
+	close(of.stop)
+	<-of.done
+	err := of.flush()
+	if err1 := of.file.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
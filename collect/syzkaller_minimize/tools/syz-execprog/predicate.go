@@ -0,0 +1,79 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/prog"
+)
+
+// execution is a single execution of a program during minimization.
+type execution struct {
+	p *prog.Prog
+	// callIndex is the index of the preserved call in p.
+	callIndex int
+	info      *ipc.ProgInfo
+	// output is the executor output, it may be nil.
+	output []byte
+}
+
+// predicate decides whether the candidate execution preserves the behavior of the original program,
+// i.e. whether the candidate can replace the original one during minimization.
+// The call of cand.callIndex is always executed.
+type predicate func(orig, cand *execution) bool
+
+// predicates holds the predicates that can be selected with -predicate.
+//
+// To compile in a custom predicate, add a file to this package (usually guarded by a build tag
+// so that it's only built on demand) that registers it in init:
+//
+//	//go:build mypredicate
+//
+//	func init() {
+//		registerPredicate("errno", func(orig, cand *execution) bool {
+//			return orig.info.Calls[orig.callIndex].Errno == cand.info.Calls[cand.callIndex].Errno
+//		})
+//	}
+//
+// and build syz-execprog with -tags mypredicate.
+var predicates = make(map[string]predicate)
+
+// defaultPredicate preserves the coverage hash of the call, it's the original SyzMini predicate.
+const defaultPredicate = "signal"
+
+func init() {
+	registerPredicate(defaultPredicate, signalPredicate)
+}
+
+func registerPredicate(name string, pred predicate) {
+	if _, ok := predicates[name]; ok {
+		panic(fmt.Sprintf("predicate %q is registered twice", name))
+	}
+	predicates[name] = pred
+}
+
+func lookupPredicate(name string) (predicate, error) {
+	pred := predicates[name]
+	if pred == nil {
+		var names []string
+		for name := range predicates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown predicate %q, available: %v", name, strings.Join(names, ", "))
+	}
+	return pred, nil
+}
+
+// signalPredicate requires the candidate call to have the coverage hash of the original call
+// and, with -matcherrno, the original errno.
+func signalPredicate(orig, cand *execution) bool {
+	inf := &orig.info.Calls[orig.callIndex]
+	return sameCallResult(&cand.info.Calls[cand.callIndex], prog.SignalHash(inf.Signal), inf.Errno,
+		*flagMatchErrno)
+}
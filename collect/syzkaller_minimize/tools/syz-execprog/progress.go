@@ -0,0 +1,81 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// progress tracks completed minimizations to report the percentage of the batch done and a rough ETA.
+// total does not include programs minimized by a previous run (index_map) or having a bad call index,
+// so that the percentage reflects the work actually left for this run. total is 0 for infinite runs.
+type progress struct {
+	total   int
+	done    int
+	failed  int
+	procs   int
+	busy    time.Duration // sum of per-program minimization times
+	printed time.Time
+}
+
+// pendingPrograms returns the number of program indices that this run is going to minimize.
+func pendingPrograms(nprogs, repeat int, minimized, invalid map[int]bool) int {
+	if repeat <= 0 {
+		return 0
+	}
+	pending := 0
+	for idx := 0; idx < nprogs*repeat; idx++ {
+		if !minimized[idx] && !invalid[idx%nprogs] {
+			pending++
+		}
+	}
+	return pending
+}
+
+// eta estimates the remaining time from the average minimization time,
+// assuming that all procs are busy minimizing.
+func (pr *progress) eta() time.Duration {
+	if pr.done == 0 || pr.total == 0 {
+		return 0
+	}
+	remaining := pr.total - pr.done - pr.failed
+	if remaining <= 0 {
+		return 0
+	}
+	procs := pr.procs
+	if procs < 1 {
+		procs = 1
+	}
+	avg := pr.busy / time.Duration(pr.done)
+	return avg * time.Duration(remaining) / time.Duration(procs)
+}
+
+func (pr *progress) String() string {
+	if pr.total == 0 {
+		return fmt.Sprintf("minimized programs: %v (failed %v)", pr.done, pr.failed)
+	}
+	finished := pr.done + pr.failed
+	return fmt.Sprintf("minimized programs: %v/%v (%.1f%%, failed %v), eta %v",
+		pr.done, pr.total, 100*float64(finished)/float64(pr.total), pr.failed,
+		pr.eta().Round(time.Second))
+}
+
+// reportProgress accounts a finished program and periodically logs the progress.
+// Programs that could not be minimized are passed with failed set.
+func (ctx *Context) reportProgress(elapsed time.Duration, failed bool) {
+	ctx.logMu.Lock()
+	defer ctx.logMu.Unlock()
+	pr := &ctx.progress
+	if failed {
+		pr.failed++
+	} else {
+		pr.done++
+		pr.busy += elapsed
+	}
+	if time.Since(pr.printed) > 5*time.Second || pr.total != 0 && pr.done+pr.failed == pr.total {
+		infof(0, "%v", pr)
+		pr.printed = time.Now()
+	}
+}
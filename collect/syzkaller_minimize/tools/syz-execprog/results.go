@@ -0,0 +1,291 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// minimizeResult describes the outcome of minimizing a single program.
+type minimizeResult struct {
+	idx              int
+	file             string
+	origCalls        int
+	finalCalls       int
+	totalExec        int
+	callExec         int
+	argExec          int
+	influenceUpdates int
+	elapsed          time.Duration
+	validation       bool
+	closureSize      int // see prog.ResourceClosureSize
+	passes           int
+	interrupted      bool // minimization was interrupted by shutdown, the result is partial
+	capped           bool // minimization hit the -maxexec limit, the result is partial
+	unstableBaseline bool // the preserved call behaved differently in -warmup executions
+	verifyRuns       int  // executions of the minimized program with -verifyruns, 0 if not verified
+	verifyPassed     int  // verification runs that reproduced the result of the preserved call
+	nondeterministic bool // with -forcesync, the synchronous program doesn't reproduce the result
+}
+
+// unstable returns true if the minimized program failed the majority of -verifyruns executions.
+func (res *minimizeResult) unstable() bool {
+	return unstableVerification(res.verifyPassed, res.verifyRuns)
+}
+
+// New columns are appended at the end, so that positional consumers of existing CSVs keep working
+// and rows appended to the CSV of a previous run still match its header.
+var csvHeader = []string{"idx", "orig_calls", "final_calls", "total_exec", "call_exec", "arg_exec",
+	"influence_updates", "elapsed_ms", "split", "closure_size", "passes", "interrupted", "capped",
+	"unstable_baseline", "verify_runs", "verify_passed", "unstable",
+	"nondeterministic", "file"}
+
+// csvWriter writes one row per minimized program.
+// Rows are flushed as soon as they are written, so an interrupted run still leaves a valid CSV.
+type csvWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *csv.Writer
+}
+
+func openCSV(filename string) (*csvWriter, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	cw := &csvWriter{
+		file: file,
+		w:    csv.NewWriter(file),
+	}
+	// Don't duplicate the header when appending to the results of a previous run.
+	if stat.Size() == 0 {
+		if err := cw.writeRecord(csvHeader); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) write(res *minimizeResult) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.writeRecord([]string{
+		strconv.Itoa(res.idx),
+		strconv.Itoa(res.origCalls),
+		strconv.Itoa(res.finalCalls),
+		strconv.Itoa(res.totalExec),
+		strconv.Itoa(res.callExec),
+		strconv.Itoa(res.argExec),
+		strconv.Itoa(res.influenceUpdates),
+		strconv.FormatInt(res.elapsed.Milliseconds(), 10),
+		splitName(res.validation),
+		strconv.Itoa(res.closureSize),
+		strconv.Itoa(res.passes),
+		strconv.FormatBool(res.interrupted),
+		strconv.FormatBool(res.capped),
+		strconv.FormatBool(res.unstableBaseline),
+		strconv.Itoa(res.verifyRuns),
+		strconv.Itoa(res.verifyPassed),
+		strconv.FormatBool(res.unstable()),
+		strconv.FormatBool(res.nondeterministic),
+		res.file,
+	})
+}
+
+func (cw *csvWriter) writeRecord(record []string) error {
+	if err := cw.w.Write(record); err != nil {
+		return err
+	}
+	cw.w.Flush()
+	if err := cw.w.Error(); err != nil {
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+	return nil
+}
+
+func (cw *csvWriter) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.w.Flush()
+	return cw.file.Close()
+}
+
+// resultTotals aggregates results of a group of minimized programs.
+type resultTotals struct {
+	programs   int
+	origCalls  int
+	finalCalls int
+	totalExec  int
+	capped     int
+}
+
+func (t *resultTotals) add(res *minimizeResult) {
+	t.programs++
+	t.origCalls += res.origCalls
+	t.finalCalls += res.finalCalls
+	t.totalExec += res.totalExec
+	if res.capped {
+		t.capped++
+	}
+}
+
+func (t *resultTotals) String() string {
+	if t.programs == 0 {
+		return "0 programs"
+	}
+	n := float64(t.programs)
+	res := fmt.Sprintf("%v programs, avg calls %.2f -> %.2f, avg exec %.2f",
+		t.programs, float64(t.origCalls)/n, float64(t.finalCalls)/n, float64(t.totalExec)/n)
+	if t.capped != 0 {
+		res += fmt.Sprintf(", %v hit -maxexec", t.capped)
+	}
+	return res
+}
+
+// fileStats aggregates minimization results per program file.
+type fileStats struct {
+	mu    sync.Mutex
+	files map[string]*resultTotals
+}
+
+func (fs *fileStats) add(res *minimizeResult) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.files == nil {
+		fs.files = make(map[string]*resultTotals)
+	}
+	totals := fs.files[res.file]
+	if totals == nil {
+		totals = new(resultTotals)
+		fs.files[res.file] = totals
+	}
+	totals.add(res)
+}
+
+// print prints per-file totals ordered by the number of removed calls,
+// so that files that contributed the most reducible programs come first.
+func (fs *fileStats) print(w io.Writer) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var files []string
+	for file := range fs.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		ti, tj := fs.files[files[i]], fs.files[files[j]]
+		ri, rj := ti.origCalls-ti.finalCalls, tj.origCalls-tj.finalCalls
+		if ri != rj {
+			return ri > rj
+		}
+		return files[i] < files[j]
+	})
+	for _, file := range files {
+		fmt.Fprintf(w, "%v: %v\n", file, fs.files[file])
+	}
+}
+
+// reductionBuckets are upper bounds (exclusive) of per-program call reduction percentages
+// in the run summary, the last bucket holds the rest.
+var reductionBuckets = []int{1, 25, 50, 75}
+
+// runSummary aggregates results of all programs of a run, it is printed at shutdown.
+type runSummary struct {
+	mu      sync.Mutex
+	totals  resultTotals
+	buckets [5]int // indexed as reductionBuckets plus the last bucket
+	skipped int
+	failed  int
+	// unstable is the number of minimized programs that failed most of -verifyruns executions.
+	unstable int
+	// nondeterministic is the number of programs that don't reproduce without async, rerun
+	// and fault injection, see -forcesync.
+	nondeterministic int
+}
+
+func (s *runSummary) add(res *minimizeResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totals.add(res)
+	if res.unstable() {
+		s.unstable++
+	}
+	if res.nondeterministic {
+		s.nondeterministic++
+	}
+	reduction := 0
+	if res.origCalls != 0 {
+		reduction = 100 * (res.origCalls - res.finalCalls) / res.origCalls
+	}
+	bucket := len(reductionBuckets)
+	for i, bound := range reductionBuckets {
+		if reduction < bound {
+			bucket = i
+			break
+		}
+	}
+	s.buckets[bucket]++
+}
+
+// skip accounts a program that was not minimized because its baseline is unusable.
+func (s *runSummary) skip() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped++
+}
+
+// fail accounts a program that failed to execute or minimize.
+func (s *runSummary) fail() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed++
+}
+
+func (s *runSummary) print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := &s.totals
+	fmt.Fprintf(w, "summary: %v programs minimized, %v skipped, %v failed\n", t.programs, s.skipped, s.failed)
+	if t.programs == 0 {
+		return
+	}
+	if s.unstable != 0 {
+		fmt.Fprintf(w, "%v minimized programs are unstable (failed most of the verification runs)\n", s.unstable)
+	}
+	if s.nondeterministic != 0 {
+		fmt.Fprintf(w, "%v minimized programs require nondeterminism (async, rerun or fault injection)\n",
+			s.nondeterministic)
+	}
+	n := float64(t.programs)
+	reduction := 0.0
+	if t.origCalls != 0 {
+		reduction = 100 * float64(t.origCalls-t.finalCalls) / float64(t.origCalls)
+	}
+	fmt.Fprintf(w, "avg calls %.2f -> %.2f, overall reduction %.1f%%\n",
+		float64(t.origCalls)/n, float64(t.finalCalls)/n, reduction)
+	lower := 0
+	for i, count := range s.buckets {
+		name := fmt.Sprintf("%v%%+", lower)
+		if i < len(reductionBuckets) {
+			name = fmt.Sprintf("%v-%v%%", lower, reductionBuckets[i]-1)
+			if reductionBuckets[i]-1 == lower {
+				name = fmt.Sprintf("%v%%", lower)
+			}
+			lower = reductionBuckets[i]
+		}
+		fmt.Fprintf(w, "  reduction %-8v %v programs\n", name+":", count)
+	}
+}
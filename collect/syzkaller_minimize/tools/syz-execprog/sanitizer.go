@@ -0,0 +1,122 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/ipc"
+	"github.com/google/syzkaller/prog"
+)
+
+const (
+	sanitizerKASAN = "kasan"
+	sanitizerKCSAN = "kcsan"
+)
+
+// sanitizerReport is the header of a KASAN or KCSAN report: the bug type and the first offending access.
+type sanitizerReport struct {
+	Type   string // e.g. slab-use-after-free or data-race
+	Access string // read, write or read-write
+	Size   int
+}
+
+func (rep *sanitizerReport) String() string {
+	return fmt.Sprintf("%v (%v of size %v)", rep.Type, rep.Access, rep.Size)
+}
+
+var (
+	kasanTitleRe  = regexp.MustCompile(`BUG: KASAN: ([a-z0-9-]+)`)
+	kasanAccessRe = regexp.MustCompile(`(Read|Write) of size ([0-9]+) at addr`)
+	kcsanTitleRe  = regexp.MustCompile(`BUG: KCSAN: ([a-z0-9-]+)`)
+	kcsanAccessRe = regexp.MustCompile(`(read-write|read|write)(?: \([a-z]+\))? to 0x[0-9a-f]+ of ([0-9]+) bytes`)
+)
+
+// parseSanitizerReport returns the first report of the given sanitizer in the kernel output,
+// or nil if there is none.
+func parseSanitizerReport(sanitizer string, output []byte) *sanitizerReport {
+	titleRe, accessRe := kasanTitleRe, kasanAccessRe
+	if sanitizer == sanitizerKCSAN {
+		titleRe, accessRe = kcsanTitleRe, kcsanAccessRe
+	}
+	title := titleRe.FindSubmatchIndex(output)
+	if title == nil {
+		return nil
+	}
+	rep := &sanitizerReport{Type: string(output[title[2]:title[3]])}
+	// KCSAN data races may have no access information if the other access is unknown,
+	// such reports are matched by the type only.
+	if access := accessRe.FindSubmatch(output[title[1]:]); access != nil {
+		rep.Access = strings.ToLower(string(access[1]))
+		rep.Size, _ = strconv.Atoi(string(access[2]))
+	}
+	return rep
+}
+
+// kernelLog returns kernel messages printed since the previous call.
+type kernelLog interface {
+	newMessages() ([]byte, error)
+}
+
+// sanitizerExec executes p and returns the first sanitizer report printed by the kernel
+// during the execution (or in the executor output).
+func sanitizerExec(env executor, klog kernelLog, opts *ipc.ExecOpts, sanitizer string, p *prog.Prog) (
+	*sanitizerReport, error) {
+	// Drop reports of previous executions.
+	if _, err := klog.newMessages(); err != nil {
+		return nil, err
+	}
+	output, _, _, _ := env.Exec(opts, p)
+	msgs, err := klog.newMessages()
+	if err != nil {
+		return nil, err
+	}
+	return parseSanitizerReport(sanitizer, append(msgs, output...)), nil
+}
+
+// sanitizerBaseline executes the original program up to reexec times until it triggers a sanitizer report.
+// If reportType is set, the report must be of that type.
+func sanitizerBaseline(env executor, klog kernelLog, opts *ipc.ExecOpts, reexec int, sanitizer, reportType string,
+	p *prog.Prog) (*sanitizerReport, error) {
+	for i := 0; i < reexec; i++ {
+		rep, err := sanitizerExec(env, klog, opts, sanitizer, p)
+		if err != nil {
+			return nil, err
+		}
+		if rep != nil && (reportType == "" || rep.Type == reportType) {
+			return rep, nil
+		}
+	}
+	if reportType != "" {
+		return nil, fmt.Errorf("no %v %v report", sanitizer, reportType)
+	}
+	return nil, fmt.Errorf("no %v report", sanitizer)
+}
+
+// sanitizerPred returns a minimization predicate that accepts a candidate as long as it triggers
+// a sanitizer report of the same type and with the same offending access as want.
+// Unlike the default predicate it doesn't look at the preserved call, so it's used with crash semantics
+// of prog.Minimize. A candidate is executed up to reexec times until it triggers the report.
+// onExec is invoked after every execution with its duration.
+func sanitizerPred(env executor, klog kernelLog, opts *ipc.ExecOpts, reexec int, sanitizer string,
+	want *sanitizerReport, onExec func(p *prog.Prog, minimizeType int, elapsed time.Duration)) func(
+	*prog.Prog, int, int) bool {
+	return func(p *prog.Prog, callIndex, minimizeType int) bool {
+		for i := 0; i < reexec; i++ {
+			start := time.Now()
+			rep, err := sanitizerExec(env, klog, opts, sanitizer, p)
+			if onExec != nil {
+				onExec(p, minimizeType, time.Since(start))
+			}
+			if err == nil && rep != nil && *rep == *want {
+				return true
+			}
+		}
+		return false
+	}
+}
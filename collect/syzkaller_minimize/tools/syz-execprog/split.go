@@ -0,0 +1,73 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+)
+
+// splitValidation selects round(fraction*n) of n program indices to hold out for validation.
+// The selection depends only on seed, not on other users of the -seed random source
+// (e.g. -influenceproportion), so a fixed seed gives the same split in every run over the same programs.
+func splitValidation(n int, fraction float64, seed int64) map[int]bool {
+	held := make(map[int]bool)
+	if n == 0 || fraction <= 0 {
+		return held
+	}
+	count := int(fraction*float64(n) + 0.5)
+	if count > n {
+		count = n
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	for _, idx := range rnd.Perm(n)[:count] {
+		held[idx] = true
+	}
+	return held
+}
+
+func splitName(validation bool) string {
+	if validation {
+		return "validation"
+	}
+	return "train"
+}
+
+// splitStats accumulates minimization results separately for the training and validation sets.
+type splitStats struct {
+	mu    sync.Mutex
+	train resultTotals
+	valid resultTotals
+}
+
+func (ss *splitStats) add(res *minimizeResult) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if res.validation {
+		ss.valid.add(res)
+	} else {
+		ss.train.add(res)
+	}
+}
+
+func (ss *splitStats) print(w io.Writer) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	fmt.Fprintf(w, "%v: %v\n", splitName(false), &ss.train)
+	fmt.Fprintf(w, "%v: %v\n", splitName(true), &ss.valid)
+}
+
+// total returns the totals over both sets.
+func (ss *splitStats) total() resultTotals {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return resultTotals{
+		programs:   ss.train.programs + ss.valid.programs,
+		origCalls:  ss.train.origCalls + ss.valid.origCalls,
+		finalCalls: ss.train.finalCalls + ss.valid.finalCalls,
+		totalExec:  ss.train.totalExec + ss.valid.totalExec,
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/syzkaller/prog"
+)
+
+// sweepPoint holds results of minimizing all programs with one influence proportion.
+type sweepPoint struct {
+	proportion int
+	totals     resultTotals
+}
+
+// parseInfluenceSweep parses a comma-separated list of influence proportions (percents).
+func parseInfluenceSweep(list string) ([]int, error) {
+	var proportions []int
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad influence proportion %q: %w", s, err)
+		}
+		if v < 0 || v > 100 {
+			return nil, fmt.Errorf("influence proportion %v is not within [0, 100]", v)
+		}
+		proportions = append(proportions, v)
+	}
+	return proportions, nil
+}
+
+// runInfluenceSweep minimizes the same programs once per proportion. Each sweep point starts
+// from a copy of the full static matrix, so that neither zeroing nor edges learned with -learn
+// leak into the next point. newContext must return a fresh context for every point.
+func runInfluenceSweep(target *prog.Target, proportions []int, rnd *rand.Rand,
+	newContext func() *Context) []sweepPoint {
+	base := target.CloneInfluenceMatrix()
+	defer target.SetInfluenceMatrix(base, target.Revision)
+	var points []sweepPoint
+	for _, proportion := range proportions {
+		target.SetInfluenceMatrix(base, target.Revision)
+		applyInfluenceProportion(target, proportion, rnd)
+		infof(0, "sweep: minimizing with influence proportion %v%%", proportion)
+		ctx := newContext()
+		ctx.runWorkers()
+		points = append(points, sweepPoint{
+			proportion: proportion,
+			totals:     ctx.splitStats.total(),
+		})
+		select {
+		case <-ctx.shutdown:
+			return points
+		default:
+		}
+	}
+	return points
+}
+
+func printInfluenceSweep(w io.Writer, points []sweepPoint) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "proportion\tprograms\tavg final calls\tavg exec\n")
+	for _, point := range points {
+		t := &point.totals
+		finalCalls, totalExec := 0.0, 0.0
+		if t.programs != 0 {
+			finalCalls = float64(t.finalCalls) / float64(t.programs)
+			totalExec = float64(t.totalExec) / float64(t.programs)
+		}
+		fmt.Fprintf(tw, "%v%%\t%v\t%.2f\t%.2f\n", point.proportion, t.programs, finalCalls, totalExec)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/syzkaller/prog"
+)
+
+// syscallTimes accumulates, per syscall name, wall time of executions of programs that contain
+// the syscall. A program is accounted once per syscall regardless of how many times it calls it.
+// Since whole programs are timed, the numbers point at syscalls that make programs slow to execute
+// rather than measure the syscalls themselves.
+type syscallTimes struct {
+	mu    sync.Mutex
+	times map[string]time.Duration
+	execs map[string]int
+}
+
+func newSyscallTimes() *syscallTimes {
+	return &syscallTimes{
+		times: make(map[string]time.Duration),
+		execs: make(map[string]int),
+	}
+}
+
+func (st *syscallTimes) add(p *prog.Prog, elapsed time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, c := range p.Calls {
+		if name := c.Meta.Name; !seen[name] {
+			seen[name] = true
+			st.times[name] += elapsed
+			st.execs[name]++
+		}
+	}
+}
+
+// print prints top syscalls with the highest cumulative execution time.
+func (st *syscallTimes) print(w io.Writer, top int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var names []string
+	for name := range st.times {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if st.times[names[i]] != st.times[names[j]] {
+			return st.times[names[i]] > st.times[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > top {
+		names = names[:top]
+	}
+	for _, name := range names {
+		fmt.Fprintf(w, "%v: %v in %v executions\n", name, st.times[name].Round(time.Millisecond), st.execs[name])
+	}
+}
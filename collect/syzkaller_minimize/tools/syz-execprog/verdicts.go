@@ -0,0 +1,161 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/syzkaller/pkg/hash"
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/prog"
+)
+
+// A verdicts file records every predicate invocation of a minimization run, one per line:
+// "program-index candidate-hash minimize-type verdict", where candidate-hash is the hash of
+// the serialized candidate program and verdict is 0 or 1. Lines of a single program are
+// in the order of invocation, lines of different programs may interleave.
+type verdict struct {
+	hash         string
+	minimizeType int
+	ok           bool
+}
+
+type verdictRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+func openVerdictRecorder(filename string) (*verdictRecorder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &verdictRecorder{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+func (vr *verdictRecorder) record(idx int, p *prog.Prog, minimizeType int, ok bool) {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	res := 0
+	if ok {
+		res = 1
+	}
+	if _, err := fmt.Fprintf(vr.w, "%v %v %v %v\n", idx, hash.String(p.Serialize()), minimizeType, res); err != nil {
+		log.Logf(0, "failed to record predicate verdict: %v", err)
+	}
+}
+
+func (vr *verdictRecorder) Close() error {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	err := vr.w.Flush()
+	if err1 := vr.file.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func loadVerdicts(filename string) (map[int][]verdict, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	verdicts, err := parseVerdicts(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%v:%w", filename, err)
+	}
+	return verdicts, nil
+}
+
+func parseVerdicts(data string) (map[int][]verdict, error) {
+	verdicts := make(map[int][]verdict)
+	for i, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%v: want 4 fields, got %q", i+1, line)
+		}
+		idx, err1 := strconv.Atoi(fields[0])
+		minimizeType, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || idx < 0 || fields[3] != "0" && fields[3] != "1" {
+			return nil, fmt.Errorf("%v: bad verdict %q", i+1, line)
+		}
+		verdicts[idx] = append(verdicts[idx], verdict{
+			hash:         fields[1],
+			minimizeType: minimizeType,
+			ok:           fields[3] == "1",
+		})
+	}
+	return verdicts, nil
+}
+
+// verdictReplayer is a deterministic predicate that returns recorded verdicts in order.
+// If the minimizer asks about a candidate other than the recorded one, the minimization logic
+// has diverged from the recorded run; all remaining candidates are rejected then.
+type verdictReplayer struct {
+	verdicts []verdict
+	pos      int
+	err      error
+}
+
+func (vr *verdictReplayer) pred(p *prog.Prog, callIndex, minimizeType int) bool {
+	if vr.err != nil {
+		return false
+	}
+	if vr.pos >= len(vr.verdicts) {
+		vr.err = fmt.Errorf("ran out of %v recorded verdicts", len(vr.verdicts))
+		return false
+	}
+	v := vr.verdicts[vr.pos]
+	if h := hash.String(p.Serialize()); h != v.hash || minimizeType != v.minimizeType {
+		vr.err = fmt.Errorf("diverged at step %v: candidate %v/%v, recorded %v/%v",
+			vr.pos, h, minimizeType, v.hash, v.minimizeType)
+		return false
+	}
+	vr.pos++
+	return v.ok
+}
+
+// replayVerdicts minimizes the programs recorded in the verdicts file using the recorded verdicts
+// instead of the executor and prints the results.
+func replayVerdicts(filename string, progs []*programEntry, opts prog.MinimizeOptions, passes int) {
+	verdicts, err := loadVerdicts(filename)
+	if err != nil {
+		log.Fatalf("failed to load verdicts: %v", err)
+	}
+	var indices []int
+	for idx := range verdicts {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+	for _, idx := range indices {
+		entry := progs[idx%len(progs)]
+		replayer := &verdictReplayer{verdicts: verdicts[idx]}
+		p, callIndex, _, err := minimizePasses(entry.p, entry.callIndex, passes,
+			func(p *prog.Prog, callIndex int) (*prog.Prog, int, error) {
+				return prog.MinimizeWithOptions(p, callIndex, false, replayer.pred, opts)
+			})
+		if err == nil {
+			err = replayer.err
+		}
+		if err == nil && replayer.pos != len(replayer.verdicts) {
+			err = fmt.Errorf("used %v of %v recorded verdicts", replayer.pos, len(replayer.verdicts))
+		}
+		if err != nil {
+			log.Logf(0, "program %v (%v): replay failed: %v", idx, entry.file, err)
+			continue
+		}
+		fmt.Printf("program %v (%v): %v -> %v calls, call index %v\n%s\n",
+			idx, entry.file, len(entry.p.Calls), len(p.Calls), callIndex, p.Serialize())
+	}
+}
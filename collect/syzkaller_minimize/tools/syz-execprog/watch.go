@@ -0,0 +1,120 @@
+// Copyright 2024 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/pkg/log"
+	"github.com/google/syzkaller/prog"
+)
+
+// watchInterval is the period of polling -programdir for new files with -watch.
+const watchInterval = 5 * time.Second
+
+// watch polls -programdir for new program files until shutdown and minimizes programs from them.
+// New programs get indices after all known programs, so indices recorded in -outpath and the checkpoint
+// stay valid during the run.
+func (ctx *Context) watch(target *prog.Target) {
+	known := make(map[string]bool)
+	for _, fn := range file_path_ary {
+		known[fn] = true
+	}
+	infof(0, "watching %v for new programs", *flagProgramDirPath)
+	for {
+		select {
+		case <-ctx.shutdown:
+			return
+		case <-time.After(watchInterval):
+		}
+		files, err := newProgramFiles(*flagProgramDirPath, known)
+		if err != nil {
+			log.Logf(0, "failed to read program dir %v: %v", *flagProgramDirPath, err)
+			continue
+		}
+		if len(files) == 0 {
+			continue
+		}
+		if len(file_path_ary) != 0 && files[0] < file_path_ary[len(file_path_ary)-1] {
+			log.Logf(0, "new file %v sorts before known files, a resumed run will assign different "+
+				"program indices", files[0])
+		}
+		entries, loaded := loadNewProgramFiles(target, files)
+		for _, fn := range loaded {
+			known[fn] = true
+		}
+		if len(loaded) == 0 {
+			continue
+		}
+		infof(0, "found %v new programs in %v files", len(entries), len(loaded))
+		ctx.addPrograms(entries)
+		ctx.runWorkers()
+	}
+}
+
+// loadNewProgramFiles loads programs from files found by watch and appends the loaded files to
+// file_path_ary and call_index_ary. Files that can't be read (e.g. removed after they were listed)
+// are logged and skipped, so they are retried on the next poll.
+func loadNewProgramFiles(target *prog.Target, files []string) ([]*programEntry, []string) {
+	var entries []*programEntry
+	var loaded []string
+	for _, fn := range files {
+		callIndex, err := parseCallIndex(filepath.Base(fn))
+		if err != nil {
+			log.Logf(1, "%v", err)
+		}
+		fileEntries, err := loadProgramEntries(target, []string{fn}, []int{callIndex})
+		if err != nil {
+			log.Logf(0, "skipping new file: %v", err)
+			continue
+		}
+		file_path_ary = append(file_path_ary, fn)
+		call_index_ary = append(call_index_ary, callIndex)
+		entries = append(entries, fileEntries...)
+		loaded = append(loaded, fn)
+	}
+	return entries, loaded
+}
+
+// newProgramFiles returns sorted paths of files in dir that are not in known.
+// Hidden files are skipped, so a program can be written to a hidden file first
+// and renamed once it's complete.
+func newProgramFiles(dir string, known map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		fn := dir + "/" + entry.Name()
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || known[fn] {
+			continue
+		}
+		files = append(files, fn)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// addPrograms appends new programs to the ones being minimized and makes workers continue
+// with the first of them. It must not be called while workers are running.
+func (ctx *Context) addPrograms(entries []*programEntry) {
+	ctx.posMu.Lock()
+	defer ctx.posMu.Unlock()
+	start := len(ctx.progs)
+	ctx.progs = append(ctx.progs, entries...)
+	invalid := validateCallIndices(entries)
+	for idx := range invalid {
+		ctx.invalid[start+idx] = true
+	}
+	// Workers that found no more programs have already taken indices past the end.
+	ctx.pos = start
+	ctx.logMu.Lock()
+	ctx.progress.total += len(entries) - len(invalid)
+	ctx.logMu.Unlock()
+}